@@ -0,0 +1,68 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bitset
+
+import (
+	"sync/atomic"
+)
+
+// Atomic bit operations update a single word with a compare-and-swap
+// loop, so goroutines may concurrently use them on any bits of the same
+// BitSet without a lock. Mixing them with the ordinary methods while
+// other goroutines are writing is a data race.
+
+// Set bit i to 1 atomically
+func (b *BitSet) SetBitAtomic(i uint) {
+	b.mustExist()
+	if err := b.check(i); err != nil {
+		panic(err)
+	}
+	p, mask := &b.set[i>>6], uint64(1)<<(i&(64-1))
+	for {
+		old := atomic.LoadUint64(p)
+		if old&mask != 0 || atomic.CompareAndSwapUint64(p, old, old|mask) {
+			return
+		}
+	}
+}
+
+// Clear bit i to 0 atomically
+func (b *BitSet) ClearBitAtomic(i uint) {
+	b.mustExist()
+	if err := b.check(i); err != nil {
+		panic(err)
+	}
+	p, mask := &b.set[i>>6], uint64(1)<<(i&(64-1))
+	for {
+		old := atomic.LoadUint64(p)
+		if old&mask == 0 || atomic.CompareAndSwapUint64(p, old, old&^mask) {
+			return
+		}
+	}
+}
+
+// Test whether bit i is set, reading its word atomically
+func (b *BitSet) TestBitAtomic(i uint) bool {
+	if err := b.check(i); err != nil {
+		panic(err)
+	}
+	return atomic.LoadUint64(&b.set[i>>6])&(1<<(i&(64-1))) != 0
+}
+
+// Copy of b reading each word atomically, so it may run while other
+// goroutines write b through the atomic bit operations. Every word of the
+// copy is one the writers actually stored, never a torn mix; the words are
+// read one after another, though, so the copy as a whole need not match
+// the set at any single instant. The copy of nil is nil.
+func (b *BitSet) CloneConsistent() *BitSet {
+	if b == nil {
+		return nil
+	}
+	c := New(b.capacity)
+	for i := range c.set {
+		c.set[i] = atomic.LoadUint64(&b.set[i])
+	}
+	return c
+}
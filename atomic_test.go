@@ -0,0 +1,89 @@
+// Copyright 2011 Will Fitzgerald. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// This file tests atomic bit operations
+
+package bitset
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestAtomicBits(t *testing.T) {
+	v := New(100)
+	v.SetBitAtomic(70)
+	if !v.TestBitAtomic(70) || v.Count() != 1 {
+		t.Errorf("SetBitAtomic(70) should set only bit 70")
+	}
+	v.ClearBitAtomic(70)
+	if v.TestBitAtomic(70) || v.Count() != 0 {
+		t.Errorf("ClearBitAtomic(70) should clear bit 70")
+	}
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("SetBitAtomic out of range should have caused a panic")
+		}
+	}()
+	v.SetBitAtomic(100)
+}
+
+func TestAtomicBitsConcurrent(t *testing.T) {
+	const goroutines, capacity = 16, 4096
+	v := New(capacity)
+	var wg sync.WaitGroup
+	for g := uint(0); g < goroutines; g++ {
+		wg.Add(1)
+		go func(g uint) {
+			defer wg.Done()
+			// goroutines share words but never bits
+			for i := g; i < capacity; i += goroutines {
+				v.SetBitAtomic(i)
+				if !v.TestBitAtomic(i) {
+					t.Errorf("Bit %d is clear right after SetBitAtomic", i)
+				}
+				if i%3 == 0 {
+					v.ClearBitAtomic(i)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	want := uint(capacity - (capacity+2)/3)
+	if v.Count() != want {
+		t.Errorf("Count reported as %d, but it should be %d", v.Count(), want)
+	}
+}
+
+func TestCloneConsistent(t *testing.T) {
+	const goroutines, capacity = 8, 4096
+	v := New(capacity)
+	var wg sync.WaitGroup
+	for g := uint(0); g < goroutines; g++ {
+		wg.Add(1)
+		go func(g uint) {
+			defer wg.Done()
+			for i := g; i < capacity; i += goroutines {
+				v.SetBitAtomic(i)
+			}
+		}(g)
+	}
+	// writers only set bits, so each clone must contain the one before
+	prev := New(capacity)
+	for k := 0; k < 50; k++ {
+		c := v.CloneConsistent()
+		if c.Cap() != capacity || !prev.IsSubset(c) {
+			t.Fatalf("CloneConsistent lost bits seen by an earlier clone")
+		}
+		prev = c
+	}
+	wg.Wait()
+	if c := v.CloneConsistent(); !c.Equ(v) || c.Count() != capacity {
+		t.Errorf("CloneConsistent after the writers finish should equal the set")
+	}
+	var e *BitSet
+	if e.CloneConsistent() != nil {
+		t.Errorf("CloneConsistent of nil should be nil")
+	}
+}
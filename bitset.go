@@ -9,6 +9,16 @@
 	upper limit, setting and testing bit locations, and clearing
 	bit locations as well as the entire set.
 
+	A nil *BitSet acts as an empty set of capacity 0 for the methods
+	that only read it, such as Cap, Bit, Count and Any; Bit, like any
+	index query on an empty set, then panics as out of range. Methods
+	that modify the set panic on nil, except Clear, which does nothing.
+
+	An out-of-range index panics by default. A set made by
+	NewWithOptions(capacity, WithBoundsErrors()) instead has Bit,
+	SetBit and ClearBit record the first error for Err and carry on,
+	until ClearErr or Reset.
+
 	Example use:
 
 	b := bitset.New(64000)
@@ -18,54 +28,403 @@
 		b.ClearBit(1000)
 	}
 	b.Clear()
+
+	c := bitset.NewWithBits(10, 1, 3, 7)
 	
 */
 package bitset
 
 import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
+	"io"
+	"math/bits"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 // BitSet internal details 
 type BitSet struct {
 	capacity uint
 	set      []uint64
+	// set by WithBoundsErrors; err is the first bounds error recorded,
+	// swapped in atomically since Bit may record it from concurrent readers
+	boundsErrors bool
+	err          atomic.Pointer[error]
 }
 
-// Make a BitSet with an upper limit on size.
+// Make a BitSet with an upper limit on size. New(0) is a valid empty set.
 func New(capacity uint) *BitSet {
-	return &BitSet{capacity, make([]uint64, (capacity+(64-1))>>6)}
+	return &BitSet{capacity: capacity, set: make([]uint64, wordsFor(capacity))}
+}
+
+// Option configures a BitSet made by NewWithOptions.
+type Option func(*BitSet)
+
+// Make Bit, SetBit and ClearBit tolerate an out-of-range index: instead
+// of panicking, Bit reports false and SetBit and ClearBit do nothing,
+// and the first such error is kept for Err. Other methods, and sets
+// derived from this one by Clone, And and the like, panic as usual.
+func WithBoundsErrors() Option {
+	return func(b *BitSet) {
+		b.boundsErrors = true
+	}
+}
+
+// Make a BitSet like New, configured by opts. With no options it is
+// exactly New(capacity), panicking on a bad index.
+func NewWithOptions(capacity uint, opts ...Option) *BitSet {
+	b := New(capacity)
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// The first bounds error recorded under WithBoundsErrors, or nil. The
+// error is sticky: later errors do not replace it, and decoding new
+// contents into b keeps it, until ClearErr or Reset. Recording and
+// reading it are atomic, so goroutines may share b for Bit and Err as
+// they could without the option. A nil BitSet has no error.
+func (b *BitSet) Err() error {
+	if b == nil {
+		return nil
+	}
+	if p := b.err.Load(); p != nil {
+		return *p
+	}
+	return nil
+}
+
+// Forget the error recorded for Err, so the next bounds error is kept.
+// Does nothing on nil.
+func (b *BitSet) ClearErr() {
+	if b != nil {
+		b.err.Store(nil)
+	}
+}
+
+// Report whether i is out of range and b tolerates it, recording the
+// error for Err if it is the first.
+func (b *BitSet) tolerate(i uint) bool {
+	if b == nil || !b.boundsErrors || i < b.capacity {
+		return false
+	}
+	// once an error is kept, later ones are dropped without allocating
+	if b.err.Load() == nil {
+		var err error = ErrOutOfRange{i, b.capacity}
+		b.err.CompareAndSwap(nil, &err)
+	}
+	return true
+}
+
+// Largest capacity NewSafe accepts: 2^51-1 bits, or 256 TiB of words,
+// where uint has 64 bits, which is what a 48-bit heap address space can
+// hold; the whole uint range where it has 32. This is a limit of
+// addressing, not of memory: sets far below it are still too large for
+// any real machine.
+const MaxCapacity = ^uint(0) >> ((bits.UintSize - 32) / 32 * 13)
+
+// Make a BitSet like New, but return an error when capacity exceeds
+// MaxCapacity, where New would overflow the word count or panic in
+// makeslice. This does not make an untrusted capacity safe: one below
+// MaxCapacity may still exceed available memory, and the runtime then
+// stops the process with a fatal out-of-memory error that cannot be
+// recovered. Check untrusted sizes against a realistic ceiling with
+// NewBounded.
+func NewSafe(capacity uint) (*BitSet, error) {
+	if capacity > MaxCapacity {
+		return nil, fmt.Errorf("bitset: capacity %d exceeds MaxCapacity %d", capacity, MaxCapacity)
+	}
+	return New(capacity), nil
+}
+
+// Make a BitSet like New, but return an error if capacity exceeds the
+// caller's own ceiling maxBits. Capacities are uint, so one computed by an
+// arithmetic that underflowed (0-1) arrives as a huge value; checking it
+// against a ceiling that the caller knows is sane reports the bug here
+// rather than as a failed allocation or an out-of-range panic later.
+func NewBounded(capacity, maxBits uint) (*BitSet, error) {
+	if capacity > maxBits {
+		return nil, fmt.Errorf("bitset: capacity %d exceeds bound %d", capacity, maxBits)
+	}
+	return NewSafe(capacity)
+}
+
+// Make a BitSet of the given capacity from bits packed little-endian in
+// data, as produced by ToBytes. data must hold at least ceil(capacity/8)
+// bytes; later bytes and bits at or beyond capacity are ignored.
+func FromBytes(capacity uint, data []byte) (*BitSet, error) {
+	n := capacity>>3 + (capacity&7+7)>>3
+	if uint(len(data)) < n {
+		return nil, fmt.Errorf("bitset: capacity %d needs %d bytes, got %d", capacity, n, len(data))
+	}
+	b := New(capacity)
+	for i, c := range data[:n] {
+		b.set[i>>3] |= uint64(c) << (uint(i) & 7 << 3)
+	}
+	b.trim()
+	return b, nil
+}
+
+// Make a BitSet of the given capacity from 32-bit words as produced by
+// ToUint32s, word 0 holding bits 0-31. words must hold at least
+// ceil(capacity/32) entries; later words and bits at or beyond capacity
+// are ignored.
+func FromUint32s(capacity uint, words []uint32) (*BitSet, error) {
+	n := capacity>>5 + (capacity&31+31)>>5
+	if uint(len(words)) < n {
+		return nil, fmt.Errorf("bitset: capacity %d needs %d 32-bit words, got %d", capacity, n, len(words))
+	}
+	b := New(capacity)
+	for i, w := range words[:n] {
+		b.set[i>>1] |= uint64(w) << (uint(i) & 1 << 5)
+	}
+	b.trim()
+	return b, nil
+}
+
+// Make a BitSet of capacity len(bits) with bit i set where bits[i] is true.
+func FromBoolSlice(bits []bool) *BitSet {
+	b := New(uint(len(bits)))
+	for i, on := range bits {
+		if on {
+			b.set[i>>6] |= 1 << (uint(i) & (64 - 1))
+		}
+	}
+	return b
+}
+
+// Make a BitSet of capacity len(s) from a string of '0' and '1'
+// characters, bit 0 first, as produced by DumpAsBits.
+func NewFromBits(s string) (*BitSet, error) {
+	b := New(uint(len(s)))
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '1':
+			b.set[i>>6] |= 1 << (uint(i) & (64 - 1))
+		case '0':
+		default:
+			return nil, fmt.Errorf("bitset: invalid character %q at position %d", s[i], i)
+		}
+	}
+	return b, nil
+}
+
+// Make a BitSet of the given capacity with the listed bits set.
+// Duplicates are harmless; an index at or beyond capacity panics as in
+// SetBit.
+func NewFromIndices(capacity uint, indices []uint) *BitSet {
+	b := New(capacity)
+	for _, i := range indices {
+		b.SetBit(i)
+	}
+	return b
+}
+
+// Make a BitSet of the given capacity with the listed bits set, as in
+// NewWithBits(10, 1, 3, 7). An index at or beyond capacity panics as in
+// SetBit.
+func NewWithBits(capacity uint, bits ...uint) *BitSet {
+	return NewFromIndices(capacity, bits)
+}
+
+// Copy of b with the same capacity and bits. The clone of nil is nil.
+// Like the other ordinary methods, Clone must not run while another
+// goroutine writes b; against writers using SetBitAtomic and
+// ClearBitAtomic, use CloneConsistent.
+func (b *BitSet) Clone() *BitSet {
+	if b == nil {
+		return nil
+	}
+	c := New(b.capacity)
+	copy(c.set, b.set)
+	return c
 }
 
 // Query maximum size of a bit set
 func (b *BitSet) Cap() uint {
-	return b.capacity
+	return b.size()
+}
+
+// Panic if b is nil. Methods that modify a BitSet call this first, while
+// methods that only read treat nil as an empty set of capacity 0.
+func (b *BitSet) mustExist() {
+	if b == nil {
+		panic("bitset: cannot modify a nil BitSet")
+	}
+}
+
+// Error for an index at or beyond the capacity of a BitSet.
+type ErrOutOfRange struct {
+	Index    uint
+	Capacity uint
+}
+
+func (e ErrOutOfRange) Error() string {
+	return fmt.Sprintf("index out of range: %v (capacity %v)", e.Index, e.Capacity)
+}
+
+// Check that bit i lies within the capacity of b.
+func (b *BitSet) check(i uint) error {
+	if i >= b.size() {
+		return ErrOutOfRange{i, b.size()}
+	}
+	return nil
 }
 
 /// Test whether bit i is set. 
 func (b *BitSet) Bit(i uint) bool {
-	if i >= b.capacity {
-		panic(fmt.Sprintf("index out of range: %v", i))
+	if b.tolerate(i) {
+		return false
+	}
+	if err := b.check(i); err != nil {
+		panic(err)
 	}
 	return ((b.set[i>>6] & (1 << (i & (64-1)))) != 0)
 }
 
 // Set bit i to 1
 func (b *BitSet) SetBit(i uint) {
-	if i >= b.capacity {
-		panic(fmt.Sprintf("index out of range: %v", i))
+	b.mustExist()
+	if b.tolerate(i) {
+		return
+	}
+	if err := b.check(i); err != nil {
+		panic(err)
 	}
 	b.set[i>>6] |= (1 << (i & (64-1)))
 }
 
 // Clear bit i to 0
 func (b *BitSet) ClearBit(i uint) {
-	if i >= b.capacity {
-		panic(fmt.Sprintf("index out of range: %v", i))
-	}	
+	b.mustExist()
+	if b.tolerate(i) {
+		return
+	}
+	if err := b.check(i); err != nil {
+		panic(err)
+	}
 	b.set[i>>6] &^= 1 << (i & (64-1))
 }
 
+// Flip bit i and return its new value
+func (b *BitSet) FlipBit(i uint) bool {
+	b.mustExist()
+	if err := b.check(i); err != nil {
+		panic(err)
+	}
+	b.set[i>>6] ^= 1 << (i & (64 - 1))
+	return b.set[i>>6]&(1<<(i&(64-1))) != 0
+}
+
+// Set each listed bit to 1. Every index is checked before any bit
+// changes, so an out-of-range index panics and leaves b untouched.
+func (b *BitSet) SetMany(indices ...uint) {
+	b.mustExist()
+	b.checkAll(indices)
+	for _, i := range indices {
+		b.set[i>>6] |= 1 << (i & (64 - 1))
+	}
+}
+
+// Clear each listed bit to 0. Every index is checked before any bit
+// changes, so an out-of-range index panics and leaves b untouched.
+func (b *BitSet) ClearMany(indices ...uint) {
+	b.mustExist()
+	b.checkAll(indices)
+	for _, i := range indices {
+		b.set[i>>6] &^= 1 << (i & (64 - 1))
+	}
+}
+
+// Panic unless every index lies within the capacity of b.
+func (b *BitSet) checkAll(indices []uint) {
+	for _, i := range indices {
+		if err := b.check(i); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Set bit i to 1 and report whether it was already set. This is a single
+// call, not an atomic operation: concurrent use needs SafeBitSet.WithLock.
+func (b *BitSet) TestAndSet(i uint) bool {
+	b.mustExist()
+	if err := b.check(i); err != nil {
+		panic(err)
+	}
+	mask := uint64(1) << (i & (64 - 1))
+	old := b.set[i>>6]
+	b.set[i>>6] = old | mask
+	return old&mask != 0
+}
+
+// Clear bit i to 0 and report whether it was set. This is a single call,
+// not an atomic operation: concurrent use needs SafeBitSet.WithLock.
+func (b *BitSet) TestAndClear(i uint) bool {
+	b.mustExist()
+	if err := b.check(i); err != nil {
+		panic(err)
+	}
+	mask := uint64(1) << (i & (64 - 1))
+	old := b.set[i>>6]
+	b.set[i>>6] = old &^ mask
+	return old&mask != 0
+}
+
+// Set bit i to 1 if value is true and to 0 otherwise, without branching
+// on value.
+func (b *BitSet) SetTo(i uint, value bool) {
+	b.mustExist()
+	if err := b.check(i); err != nil {
+		panic(err)
+	}
+	shift := i & (64 - 1)
+	b.set[i>>6] = b.set[i>>6]&^(1<<shift) | boolToWord(value)<<shift
+}
+
+func boolToWord(value bool) uint64 {
+	var w uint64
+	if value {
+		w = 1
+	}
+	return w
+}
+
+// Test whether bit i is set, returning ErrOutOfRange instead of panicking.
+func (b *BitSet) BitE(i uint) (bool, error) {
+	if err := b.check(i); err != nil {
+		return false, err
+	}
+	return b.Bit(i), nil
+}
+
+// Set bit i to 1, returning ErrOutOfRange instead of panicking.
+func (b *BitSet) SetBitE(i uint) error {
+	b.mustExist()
+	if err := b.check(i); err != nil {
+		return err
+	}
+	b.SetBit(i)
+	return nil
+}
+
+// Clear bit i to 0, returning ErrOutOfRange instead of panicking.
+func (b *BitSet) ClearBitE(i uint) error {
+	b.mustExist()
+	if err := b.check(i); err != nil {
+		return err
+	}
+	b.ClearBit(i)
+	return nil
+}
+
 // Clear entire BitSet
 func (b *BitSet) Clear() {
 	if b != nil {
@@ -75,31 +434,2124 @@ func (b *BitSet) Clear() {
 	}
 }
 
-// From Wikipedia: http://en.wikipedia.org/wiki/Hamming_weight                                     
-const m1  uint64 = 0x5555555555555555 //binary: 0101...
-const m2  uint64 = 0x3333333333333333 //binary: 00110011..
-const m4  uint64 = 0x0f0f0f0f0f0f0f0f //binary:  4 zeros,  4 ones ...
+// Set every bit of the BitSet below its capacity
+func (b *BitSet) SetAll() {
+	b.mustExist()
+	for i := range b.set {
+		b.set[i] = ^uint64(0)
+	}
+	b.trim()
+}
+
+// Flip every bit of the BitSet below its capacity
+func (b *BitSet) XorAll() {
+	b.mustExist()
+	for i := range b.set {
+		b.set[i] = ^b.set[i]
+	}
+	b.trim()
+}
 
-// From Wikipedia: count number of set bits.
-func popcount_2(x uint64) uint64 {
-    x -= (x >> 1) & m1;             //put count of each 2 bits into those 2 bits
-    x = (x & m2) + ((x >> 2) & m2); //put count of each 4 bits into those 4 bits 
-    x = (x + (x >> 4)) & m4;        //put count of each 8 bits into those 8 bits 
-    x += x >>  8;  //put count of each 16 bits into their lowest 8 bits
-    x += x >> 16;  //put count of each 32 bits into their lowest 8 bits
-    x += x >> 32;  //put count of each 64 bits into their lowest 8 bits
-    return x & 0x7f;
+// Clear the padding bits of the final word beyond the capacity.
+func (b *BitSet) trim() {
+	if n := len(b.set); n > 0 {
+		b.set[n-1] &= b.lastWordMask()
+	}
 }
 
 // Count (number of set bits)
 func (b *BitSet) Count() uint {
-   	if b != nil {
-		cnt := uint64(0)
+	if b != nil {
+		cnt := 0
 		for _, word := range b.set {
-			cnt += popcount_2(word)
+			cnt += bits.OnesCount64(word)
 		}
 		return uint(cnt)
 	}
 	return 0
 }
 
+// Words backing b; a nil BitSet has none.
+func (b *BitSet) words() []uint64 {
+	if b == nil {
+		return nil
+	}
+	return b.set
+}
+
+// Capacity of b; a nil BitSet has capacity 0.
+func (b *BitSet) size() uint {
+	if b == nil {
+		return 0
+	}
+	return b.capacity
+}
+
+func minLen(a, b []uint64) int {
+	if len(a) < len(b) {
+		return len(a)
+	}
+	return len(b)
+}
+
+func maxCap(a, b uint) uint {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Test whether b and c have the same capacity and the same bits set.
+// A nil BitSet is treated as an empty set of capacity 0.
+func (b *BitSet) Equ(c *BitSet) bool {
+	if b.size() != c.size() {
+		return false
+	}
+	cw := c.words()
+	for i, word := range b.words() {
+		if word != cw[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Intersection of b and c as a new BitSet with the capacity of b.
+// A nil c is treated as an empty set, as in Equ; bits of c beyond the
+// capacity of b are ignored.
+func (b *BitSet) And(c *BitSet) *BitSet {
+	r := New(b.size())
+	bw, cw := b.words(), c.words()
+	for i := 0; i < minLen(bw, cw); i++ {
+		r.set[i] = bw[i] & cw[i]
+	}
+	return r
+}
+
+// Union of b and c as a new BitSet with the larger of the two capacities.
+// A nil c is treated as an empty set, as in Equ.
+func (b *BitSet) Or(c *BitSet) *BitSet {
+	r := New(maxCap(b.size(), c.size()))
+	copy(r.set, b.words())
+	for i, word := range c.words() {
+		r.set[i] |= word
+	}
+	return r
+}
+
+// Symmetric difference of b and c as a new BitSet with the larger of the
+// two capacities. A nil c is treated as an empty set, as in Equ.
+func (b *BitSet) Xor(c *BitSet) *BitSet {
+	r := New(maxCap(b.size(), c.size()))
+	copy(r.set, b.words())
+	for i, word := range c.words() {
+		r.set[i] ^= word
+	}
+	return r
+}
+
+// Bits of b that are not set in c, as a new BitSet with the capacity of b.
+// A nil c is treated as an empty set, as in Equ.
+func (b *BitSet) AndNot(c *BitSet) *BitSet {
+	r := New(b.size())
+	copy(r.set, b.words())
+	cw := c.words()
+	for i := 0; i < minLen(r.set, cw); i++ {
+		r.set[i] &^= cw[i]
+	}
+	return r
+}
+
+// Number of words needed to hold capacity bits.
+func wordsFor(capacity uint) int {
+	n := int(capacity >> 6)
+	if capacity&(64-1) != 0 {
+		n++
+	}
+	return n
+}
+
+// Enlarge b to hold newCap bits, preserving its contents; the new bits
+// are clear. This is a no-op if newCap does not exceed the capacity. The
+// backing slice is extended in place when it has room to spare.
+func (b *BitSet) Grow(newCap uint) {
+	b.mustExist()
+	if newCap <= b.capacity {
+		return
+	}
+	if n := wordsFor(newCap); n > len(b.set) {
+		b.set = append(b.set, make([]uint64, n-len(b.set))...)
+	}
+	b.capacity = newCap
+}
+
+// Set bit i to 1, first growing the capacity to i+1 if it is too small.
+// When the backing array must be reallocated its size is rounded up to a
+// power of two words, so setting increasing indices in a loop costs
+// amortized constant time per bit.
+func (b *BitSet) GrowAndSet(i uint) {
+	b.mustExist()
+	if i >= b.capacity {
+		if n := wordsFor(i + 1); n > cap(b.set) {
+			size := 1
+			for size < n {
+				size <<= 1
+			}
+			set := make([]uint64, len(b.set), size)
+			copy(set, b.set)
+			b.set = set
+		}
+		b.Grow(i + 1)
+	}
+	b.set[i>>6] |= 1 << (i & (64 - 1))
+}
+
+// Intersect b with c in place. Bits of c beyond the capacity of b are
+// ignored, and a nil c is treated as an empty set.
+func (b *BitSet) AndWith(c *BitSet) {
+	b.mustExist()
+	cw := c.words()
+	for i := range b.set {
+		if i < len(cw) {
+			b.set[i] &= cw[i]
+		} else {
+			b.set[i] = 0
+		}
+	}
+}
+
+// Union c into b in place. If c has the larger capacity, b grows to match
+// it so that no bits of c are lost. A nil c is treated as an empty set.
+func (b *BitSet) OrWith(c *BitSet) {
+	b.mustExist()
+	b.Grow(c.size())
+	for i, word := range c.words() {
+		b.set[i] |= word
+	}
+}
+
+// Symmetric difference of b and c in place. If c has the larger capacity,
+// b grows to match it. A nil c is treated as an empty set.
+func (b *BitSet) XorWith(c *BitSet) {
+	b.mustExist()
+	b.Grow(c.size())
+	for i, word := range c.words() {
+		b.set[i] ^= word
+	}
+}
+
+// Clear in b every bit that is set in c. Bits of c beyond the capacity of
+// b are ignored, and a nil c is treated as an empty set.
+func (b *BitSet) AndNotWith(c *BitSet) {
+	b.mustExist()
+	cw := c.words()
+	for i := 0; i < minLen(b.set, cw); i++ {
+		b.set[i] &^= cw[i]
+	}
+}
+
+// Index of the first set bit at position i or higher, and whether one was
+// found. An i at or beyond the capacity finds nothing, so
+//
+//	for i, ok := b.NextSet(0); ok; i, ok = b.NextSet(i + 1) {
+//		...
+//	}
+//
+// visits every set bit in ascending order.
+func (b *BitSet) NextSet(i uint) (uint, bool) {
+	if i >= b.size() {
+		return 0, false
+	}
+	x := int(i >> 6)
+	word := b.set[x] >> (i & (64 - 1))
+	if word != 0 {
+		return i + uint(bits.TrailingZeros64(word)), true
+	}
+	for x++; x < len(b.set); x++ {
+		if b.set[x] != 0 {
+			return uint(x)<<6 + uint(bits.TrailingZeros64(b.set[x])), true
+		}
+	}
+	return 0, false
+}
+
+// Index of the first clear bit at position i or higher, and whether one
+// was found. Padding bits beyond the capacity in the final word are never
+// reported, even though they are physically zero.
+func (b *BitSet) NextClear(i uint) (uint, bool) {
+	if i >= b.size() {
+		return 0, false
+	}
+	x := int(i >> 6)
+	word := ^b.set[x] >> (i & (64 - 1))
+	if word != 0 {
+		i += uint(bits.TrailingZeros64(word))
+	} else {
+		for x++; x < len(b.set) && b.set[x] == ^uint64(0); x++ {
+		}
+		if x == len(b.set) {
+			return 0, false
+		}
+		i = uint(x)<<6 + uint(bits.TrailingZeros64(^b.set[x]))
+	}
+	// a clear bit found only in the padding means there is none, reported
+	// as (0, false) like any other miss
+	if i >= b.capacity {
+		return 0, false
+	}
+	return i, true
+}
+
+// Mask of the bits of the final word that lie below the capacity.
+func (b *BitSet) lastWordMask() uint64 {
+	if r := b.capacity & (64 - 1); r != 0 {
+		return 1<<r - 1
+	}
+	return ^uint64(0)
+}
+
+// Encode b as an 8-byte little-endian capacity followed by its words,
+// each 8 bytes little-endian. Implements encoding.BinaryMarshaler.
+func (b *BitSet) MarshalBinary() ([]byte, error) {
+	set := b.words()
+	data := make([]byte, 8+8*len(set))
+	binary.LittleEndian.PutUint64(data, uint64(b.size()))
+	putWords(data[8:], set)
+	return data, nil
+}
+
+// Decode data written by MarshalBinary into b, replacing its contents.
+// Implements encoding.BinaryUnmarshaler.
+func (b *BitSet) UnmarshalBinary(data []byte) error {
+	b.mustExist()
+	if len(data) < 8 {
+		return fmt.Errorf("bitset: binary data too short for capacity header: %d bytes", len(data))
+	}
+	c, err := decodeWords(binary.LittleEndian.Uint64(data), data[8:])
+	if err != nil {
+		return err
+	}
+	b.capacity, b.set = c.capacity, c.set
+	return nil
+}
+
+// Store set in data as little-endian words.
+func putWords(data []byte, set []uint64) {
+	for i, word := range set {
+		binary.LittleEndian.PutUint64(data[8*i:], word)
+	}
+}
+
+// Decode a set of the given capacity from its little-endian words,
+// checking that data holds exactly the words the capacity needs and that
+// no bit beyond the capacity is set.
+func decodeWords(capacity uint64, data []byte) (*BitSet, error) {
+	if capacity != uint64(uint(capacity)) {
+		return nil, fmt.Errorf("bitset: capacity %d overflows uint", capacity)
+	}
+	n := wordsFor(uint(capacity))
+	if uint64(len(data)) != 8*uint64(n) {
+		return nil, fmt.Errorf("bitset: capacity %d needs %d bytes of words, got %d", capacity, 8*n, len(data))
+	}
+	c := New(uint(capacity))
+	for i := range c.set {
+		c.set[i] = binary.LittleEndian.Uint64(data[8*i:])
+	}
+	if n > 0 && c.set[n-1]&^c.lastWordMask() != 0 {
+		return nil, fmt.Errorf("bitset: bits set beyond capacity %d", capacity)
+	}
+	return c, nil
+}
+
+// Words per buffer when streaming with WriteTo and ReadFrom.
+const streamWords = 512
+
+// Words ReadFrom allocates on the strength of the header alone, 8 MiB;
+// larger sets grow as their words arrive.
+const readWordsUpfront = 1 << 20
+
+// Write b to w using the MarshalBinary layout, a buffer at a time, and
+// return the number of bytes written. Implements io.WriterTo.
+func (b *BitSet) WriteTo(w io.Writer) (int64, error) {
+	var buf [8 * streamWords]byte
+	set := b.words()
+	binary.LittleEndian.PutUint64(buf[:], uint64(b.size()))
+	written, err := w.Write(buf[:8])
+	total := int64(written)
+	if err != nil {
+		return total, err
+	}
+	for x := 0; x < len(set); x += streamWords {
+		chunk := set[x:]
+		if len(chunk) > streamWords {
+			chunk = chunk[:streamWords]
+		}
+		for i, word := range chunk {
+			binary.LittleEndian.PutUint64(buf[8*i:], word)
+		}
+		written, err = w.Write(buf[:8*len(chunk)])
+		total += int64(written)
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// Read a set written by WriteTo or MarshalBinary from r into b, replacing
+// its contents, and return the number of bytes read. Truncated input
+// yields io.ErrUnexpectedEOF, and a capacity above MaxCapacity is
+// rejected. Beyond 8 MiB, storage is allocated only as words are read,
+// never from the header alone; either way the words end up in a slice of
+// exactly their size. Implements io.ReaderFrom.
+func (b *BitSet) ReadFrom(r io.Reader) (int64, error) {
+	b.mustExist()
+	var buf [8 * streamWords]byte
+	read, err := io.ReadFull(r, buf[:8])
+	total := int64(read)
+	if err != nil {
+		return total, unexpectedEOF(err)
+	}
+	capacity := binary.LittleEndian.Uint64(buf[:])
+	if capacity > uint64(MaxCapacity) {
+		return total, fmt.Errorf("bitset: capacity %d exceeds MaxCapacity %d", capacity, MaxCapacity)
+	}
+	// the header alone is trusted with at most readWordsUpfront words;
+	// beyond that the storage doubles as words arrive, capped at the
+	// exact size, so truncated input runs out before memory does
+	c := BitSet{capacity: uint(capacity)}
+	n := wordsFor(c.capacity)
+	size := n
+	if size > readWordsUpfront {
+		size = readWordsUpfront
+	}
+	c.set = make([]uint64, 0, size)
+	for len(c.set) < n {
+		if len(c.set) == cap(c.set) {
+			grown := 2 * cap(c.set)
+			if grown > n {
+				grown = n
+			}
+			set := make([]uint64, len(c.set), grown)
+			copy(set, c.set)
+			c.set = set
+		}
+		chunk := cap(c.set) - len(c.set)
+		if chunk > streamWords {
+			chunk = streamWords
+		}
+		read, err = io.ReadFull(r, buf[:8*chunk])
+		total += int64(read)
+		if err != nil {
+			return total, unexpectedEOF(err)
+		}
+		for i := 0; i < chunk; i++ {
+			c.set = append(c.set, binary.LittleEndian.Uint64(buf[8*i:]))
+		}
+	}
+	if n := len(c.set); n > 0 && c.set[n-1]&^c.lastWordMask() != 0 {
+		return total, fmt.Errorf("bitset: bits set beyond capacity %d", capacity)
+	}
+	b.capacity, b.set = c.capacity, c.set
+	return total, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Copy of the lowest newCap bits of b as a new BitSet of capacity newCap.
+// If newCap is not below the capacity of b, the copy keeps the capacity
+// of b.
+func (b *BitSet) Shrink(newCap uint) *BitSet {
+	if newCap > b.size() {
+		newCap = b.size()
+	}
+	c := New(newCap)
+	copy(c.set, b.words())
+	c.trim()
+	return c
+}
+
+// Number of bits up to and including the highest set bit of b.
+func (b *BitSet) extent() uint {
+	set := b.words()
+	for x := len(set) - 1; x >= 0; x-- {
+		if set[x] != 0 {
+			return uint(x)<<6 + 64 - uint(bits.LeadingZeros64(set[x]))
+		}
+	}
+	return 0
+}
+
+// Reduce the capacity of b to one past its highest set bit (0 if empty)
+// and move the words into a backing slice of exactly that size, releasing
+// the rest. Set bits are preserved; indices above the new capacity panic
+// as out of range like any other.
+func (b *BitSet) Compact() {
+	b.mustExist()
+	b.capacity = b.extent()
+	set := make([]uint64, wordsFor(b.capacity))
+	copy(set, b.set)
+	b.set = set
+}
+
+// Test whether any bit is set. A nil BitSet has none.
+func (b *BitSet) Any() bool {
+	for _, word := range b.words() {
+		if word != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Test whether no bit is set. A nil BitSet has none.
+func (b *BitSet) None() bool {
+	return !b.Any()
+}
+
+// Test whether every bit below the capacity is set. This holds trivially
+// for a nil or zero-capacity BitSet.
+func (b *BitSet) All() bool {
+	set := b.words()
+	if len(set) == 0 {
+		return true
+	}
+	for _, word := range set[:len(set)-1] {
+		if word != ^uint64(0) {
+			return false
+		}
+	}
+	return set[len(set)-1] == b.lastWordMask()
+}
+
+// Check that [start, end) is not inverted and lies within the capacity
+// of b, panicking with both bounds in the message otherwise.
+func (b *BitSet) checkRange(start, end uint) {
+	if start > end {
+		panic(fmt.Sprintf("range inverted: [%v, %v) (capacity %v)", start, end, b.size()))
+	}
+	if end > b.size() {
+		panic(fmt.Sprintf("range out of bounds: [%v, %v) (capacity %v)", start, end, b.size()))
+	}
+}
+
+// Words spanned by the non-empty range [start, end), with the masks of
+// the range's bits in the first and last of them.
+func rangeMasks(start, end uint) (first, last uint, lo, hi uint64) {
+	first, last = start>>6, (end-1)>>6
+	lo = ^uint64(0) << (start & (64 - 1))
+	hi = ^uint64(0) >> (64 - 1 - (end-1)&(64-1))
+	if first == last {
+		lo &= hi
+		hi = lo
+	}
+	return
+}
+
+// Set bits [start, end) to 1. Panics unless start <= end <= Cap().
+func (b *BitSet) SetRange(start, end uint) {
+	b.mustExist()
+	b.checkRange(start, end)
+	if start == end {
+		return
+	}
+	first, last, lo, hi := rangeMasks(start, end)
+	b.set[first] |= lo
+	for x := first + 1; x < last; x++ {
+		b.set[x] = ^uint64(0)
+	}
+	b.set[last] |= hi
+}
+
+// Clear bits [start, end) to 0. Panics unless start <= end <= Cap().
+func (b *BitSet) ClearRange(start, end uint) {
+	b.mustExist()
+	b.checkRange(start, end)
+	if start == end {
+		return
+	}
+	first, last, lo, hi := rangeMasks(start, end)
+	b.set[first] &^= lo
+	for x := first + 1; x < last; x++ {
+		b.set[x] = 0
+	}
+	b.set[last] &^= hi
+}
+
+// Flip bits [start, end). Panics unless start <= end <= Cap().
+func (b *BitSet) FlipRange(start, end uint) {
+	b.mustExist()
+	b.checkRange(start, end)
+	if start == end {
+		return
+	}
+	first, last, lo, hi := rangeMasks(start, end)
+	if first == last {
+		b.set[first] ^= lo
+		return
+	}
+	b.set[first] ^= lo
+	for x := first + 1; x < last; x++ {
+		b.set[x] = ^b.set[x]
+	}
+	b.set[last] ^= hi
+}
+
+// Number of set bits in [start, end), touching only the words the range
+// overlaps. Panics unless start <= end <= Cap().
+func (b *BitSet) CountRange(start, end uint) uint {
+	b.checkRange(start, end)
+	if start == end {
+		return 0
+	}
+	first, last, lo, hi := rangeMasks(start, end)
+	if first == last {
+		return uint(bits.OnesCount64(b.set[first] & lo))
+	}
+	cnt := bits.OnesCount64(b.set[first]&lo) + bits.OnesCount64(b.set[last]&hi)
+	for _, word := range b.set[first+1 : last] {
+		cnt += bits.OnesCount64(word)
+	}
+	return uint(cnt)
+}
+
+// Most indices String renders before eliding the rest.
+const maxStringIndices = 1024
+
+// Render the indices of the set bits in ascending order as "{1,999,1000}".
+// After maxStringIndices indices the rest are elided as "{0,1,...}".
+// Implements fmt.Stringer.
+func (b *BitSet) String() string {
+	var s strings.Builder
+	s.WriteByte('{')
+	n := 0
+	for i, ok := b.NextSet(0); ok; i, ok = b.NextSet(i + 1) {
+		if n > 0 {
+			s.WriteByte(',')
+		}
+		if n == maxStringIndices {
+			s.WriteString("...")
+			break
+		}
+		s.WriteString(strconv.FormatUint(uint64(i), 10))
+		n++
+	}
+	s.WriteByte('}')
+	return s.String()
+}
+
+// Render b as exactly Cap() characters, '0' or '1' for each bit, bit 0
+// first. The string takes a byte per bit, so it is meant for small sets.
+func (b *BitSet) DumpAsBits() string {
+	buf := make([]byte, b.size())
+	for i := range buf {
+		buf[i] = '0' + byte(b.set[i>>6]>>(uint(i)&(64-1))&1)
+	}
+	return string(buf)
+}
+
+// Indices of the set bits in ascending order.
+func (b *BitSet) Indices() []uint {
+	return b.OnesIndicesInto(make([]uint, 0, b.Count()))
+}
+
+// Append the indices of the set bits to dst in ascending order and return
+// the extended slice, as the strconv Append functions do. Passing a
+// reused dst[:0] with enough capacity avoids allocating.
+func (b *BitSet) OnesIndicesInto(dst []uint) []uint {
+	for x, word := range b.words() {
+		for word != 0 {
+			dst = append(dst, uint(x)<<6+uint(bits.TrailingZeros64(word)))
+			word &= word - 1
+		}
+	}
+	return dst
+}
+
+// Test whether b and c have any set bit in common, stopping at the first
+// shared word. Only the words both sets have are compared.
+func (b *BitSet) Intersects(c *BitSet) bool {
+	bw, cw := b.words(), c.words()
+	for i := 0; i < minLen(bw, cw); i++ {
+		if bw[i]&cw[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Test whether every bit set in b is also set in c. A bit of b beyond the
+// capacity of c means b cannot be a subset.
+func (b *BitSet) IsSubset(c *BitSet) bool {
+	bw, cw := b.words(), c.words()
+	for i, word := range bw {
+		if i < len(cw) {
+			word &^= cw[i]
+		}
+		if word != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Test whether every bit set in c is also set in b.
+func (b *BitSet) IsSuperset(c *BitSet) bool {
+	return c.IsSubset(b)
+}
+
+// Number of bits set in both b and c, without building the intersection.
+func (b *BitSet) IntersectionCount(c *BitSet) uint {
+	bw, cw := b.words(), c.words()
+	cnt := 0
+	for i := 0; i < minLen(bw, cw); i++ {
+		cnt += bits.OnesCount64(bw[i] & cw[i])
+	}
+	return uint(cnt)
+}
+
+// Number of bits set in b or c, without building the union. The shorter
+// set is treated as zero-extended.
+func (b *BitSet) UnionCount(c *BitSet) uint {
+	bw, cw := b.words(), c.words()
+	if len(bw) < len(cw) {
+		bw, cw = cw, bw
+	}
+	cnt := 0
+	for i, word := range bw {
+		if i < len(cw) {
+			word |= cw[i]
+		}
+		cnt += bits.OnesCount64(word)
+	}
+	return uint(cnt)
+}
+
+// Number of bits set in b but not in c, without building the difference.
+func (b *BitSet) DifferenceCount(c *BitSet) uint {
+	bw, cw := b.words(), c.words()
+	cnt := 0
+	for i, word := range bw {
+		if i < len(cw) {
+			word &^= cw[i]
+		}
+		cnt += bits.OnesCount64(word)
+	}
+	return uint(cnt)
+}
+
+// Move every bit n places toward higher indices, discarding bits pushed
+// past the capacity and clearing the n lowest bits.
+func (b *BitSet) ShiftLeft(n uint) {
+	b.mustExist()
+	if n >= b.capacity {
+		b.Clear()
+		return
+	}
+	w, off := int(n>>6), n&(64-1)
+	for i := len(b.set) - 1; i >= w; i-- {
+		word := b.set[i-w] << off
+		if off != 0 && i-w > 0 {
+			word |= b.set[i-w-1] >> (64 - off)
+		}
+		b.set[i] = word
+	}
+	for i := 0; i < w; i++ {
+		b.set[i] = 0
+	}
+	b.trim()
+}
+
+// Move every bit n places toward lower indices, discarding the n lowest
+// bits and clearing the n highest.
+func (b *BitSet) ShiftRight(n uint) {
+	b.mustExist()
+	if n >= b.capacity {
+		b.Clear()
+		return
+	}
+	w, off := int(n>>6), n&(64-1)
+	for i := 0; i < len(b.set)-w; i++ {
+		word := b.set[i+w] >> off
+		if off != 0 && i+w+1 < len(b.set) {
+			word |= b.set[i+w+1] << (64 - off)
+		}
+		b.set[i] = word
+	}
+	for i := len(b.set) - w; i < len(b.set); i++ {
+		b.set[i] = 0
+	}
+}
+
+// Complement every bit below the capacity, leaving the padding of the
+// final word clear, so Count() becomes Cap() minus its old value. This is
+// the same operation as XorAll.
+func (b *BitSet) Flip() {
+	b.XorAll()
+}
+
+// Complement every bit below the capacity; another name for Flip. XorAll
+// keeps the padding clear too, so all three are one operation.
+func (b *BitSet) FlipAll() {
+	b.Flip()
+}
+
+// JSON form of a BitSet: the capacity and the base64 of the words as laid
+// out by MarshalBinary.
+type jsonBitSet struct {
+	Cap  uint64 `json:"cap"`
+	Bits string `json:"bits"`
+}
+
+// Encode b as {"cap":100,"bits":"<base64 words>"}. Implements
+// json.Marshaler.
+func (b *BitSet) MarshalJSON() ([]byte, error) {
+	set := b.words()
+	data := make([]byte, 8*len(set))
+	putWords(data, set)
+	return json.Marshal(jsonBitSet{uint64(b.size()), base64.StdEncoding.EncodeToString(data)})
+}
+
+// Decode JSON written by MarshalJSON into b, replacing its contents.
+// Implements json.Unmarshaler.
+func (b *BitSet) UnmarshalJSON(data []byte) error {
+	b.mustExist()
+	var j jsonBitSet
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	words, err := base64.StdEncoding.DecodeString(j.Bits)
+	if err != nil {
+		return fmt.Errorf("bitset: invalid base64 bits: %v", err)
+	}
+	c, err := decodeWords(j.Cap, words)
+	if err != nil {
+		return err
+	}
+	b.capacity, b.set = c.capacity, c.set
+	return nil
+}
+
+// Encode b in the MarshalBinary layout. Implements gob.GobEncoder.
+func (b *BitSet) GobEncode() ([]byte, error) {
+	return b.MarshalBinary()
+}
+
+// Decode data written by GobEncode into b. gob hands over a zero BitSet,
+// so the words are always allocated afresh. Implements gob.GobDecoder.
+func (b *BitSet) GobDecode(data []byte) error {
+	return b.UnmarshalBinary(data)
+}
+
+// Number of set bits strictly below index i. An i beyond the capacity
+// counts every set bit.
+func (b *BitSet) Rank(i uint) uint {
+	if i >= b.size() {
+		return b.Count()
+	}
+	x := i >> 6
+	cnt := bits.OnesCount64(b.set[x] & (1<<(i&(64-1)) - 1))
+	for _, word := range b.set[:x] {
+		cnt += bits.OnesCount64(word)
+	}
+	return uint(cnt)
+}
+
+// Index of the n-th set bit, counting from 0, and whether the set has
+// that many bits.
+func (b *BitSet) Select(n uint) (uint, bool) {
+	for x, word := range b.words() {
+		cnt := uint(bits.OnesCount64(word))
+		if n < cnt {
+			return uint(x)<<6 + selectInWord(word, n), true
+		}
+		n -= cnt
+	}
+	return 0, false
+}
+
+// Position of the n-th set bit of word, which must have more than n.
+func selectInWord(word uint64, n uint) uint {
+	for ; n > 0; n-- {
+		word &= word - 1
+	}
+	return uint(bits.TrailingZeros64(word))
+}
+
+// Bits [start, end) of b as a new BitSet of capacity end-start, bit start
+// of b becoming bit 0. Panics unless start <= end <= Cap().
+func (b *BitSet) Sub(start, end uint) *BitSet {
+	b.checkRange(start, end)
+	c := New(end - start)
+	b.extract(c.set, start)
+	c.trim()
+	return c
+}
+
+// Fill dst with the words of b starting at bit start. The words of b
+// must cover at least the bits of dst that lie below the capacity.
+func (b *BitSet) extract(dst []uint64, start uint) {
+	for i := range dst {
+		dst[i] = b.wordAt(start + uint(i)<<6)
+	}
+}
+
+// The 64 bits of b from bit start, bits past the last word reading as 0.
+func (b *BitSet) wordAt(start uint) uint64 {
+	w, off := int(start>>6), start&(64-1)
+	word := b.set[w] >> off
+	// the next source word may not exist when end is near the capacity
+	if off != 0 && w+1 < len(b.set) {
+		word |= b.set[w+1] << (64 - off)
+	}
+	return word
+}
+
+// Copy bits [start, end) of b into the lowest bits of dst, clearing every
+// other bit of dst, without allocating. Returns an error if dst has less
+// than end-start capacity; like Sub, panics unless start <= end <= Cap().
+func (b *BitSet) SubInto(dst *BitSet, start, end uint) error {
+	b.checkRange(start, end)
+	length := end - start
+	if dst.size() < length {
+		return fmt.Errorf("bitset: destination capacity %d is less than range length %d", dst.size(), length)
+	}
+	set, n := dst.words(), wordsFor(length)
+	b.extract(set[:n], start)
+	if r := length & (64 - 1); r != 0 {
+		set[n-1] &= 1<<r - 1
+	}
+	for i := n; i < len(set); i++ {
+		set[i] = 0
+	}
+	return nil
+}
+
+// Test whether b and c have the same bits set, whatever their capacities,
+// so that a New(100) and a New(128) with identical bits are equal. Equ is
+// the strict variant that also compares capacities.
+func (b *BitSet) EqualBits(c *BitSet) bool {
+	bw, cw := b.words(), c.words()
+	if len(bw) < len(cw) {
+		bw, cw = cw, bw
+	}
+	for i, word := range bw {
+		if i < len(cw) {
+			if word != cw[i] {
+				return false
+			}
+		} else if word != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+const (
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
+// FNV-1a hash of the little-endian bytes of x, continuing from h.
+func fnvWord(h, x uint64) uint64 {
+	for k := 0; k < 8; k++ {
+		h ^= x & 0xff
+		h *= fnvPrime64
+		x >>= 8
+	}
+	return h
+}
+
+// 64-bit FNV-1a hash of the capacity and words of b. Sets that are Equ
+// hash alike; sets with the same bits but different capacities usually
+// do not, matching Equ rather than EqualBits. Not for cryptographic use.
+func (b *BitSet) Hash() uint64 {
+	h := fnvWord(fnvOffset64, uint64(b.size()))
+	for _, word := range b.words() {
+		h = fnvWord(h, word)
+	}
+	return h
+}
+
+// Number of bits set in both a and b; same as a.IntersectionCount(b).
+func PopCountAnd(a, b *BitSet) uint {
+	return a.IntersectionCount(b)
+}
+
+// Number of bits set in a or b; same as a.UnionCount(b).
+func PopCountOr(a, b *BitSet) uint {
+	return a.UnionCount(b)
+}
+
+// Number of bits set in exactly one of a and b, without building the
+// symmetric difference. The shorter set is treated as zero-extended.
+func PopCountXor(a, b *BitSet) uint {
+	aw, bw := a.words(), b.words()
+	if len(aw) < len(bw) {
+		aw, bw = bw, aw
+	}
+	cnt := 0
+	for i, word := range aw {
+		if i < len(bw) {
+			word ^= bw[i]
+		}
+		cnt += bits.OnesCount64(word)
+	}
+	return uint(cnt)
+}
+
+// Number of bits set in a but not in b; same as a.DifferenceCount(b).
+func PopCountAndNot(a, b *BitSet) uint {
+	return a.DifferenceCount(b)
+}
+
+// Bits of b packed little-endian into ceil(Cap()/8) bytes, bit 0 being
+// the lowest bit of the first byte. Unlike MarshalBinary there is no
+// capacity header.
+func (b *BitSet) ToBytes() []byte {
+	capacity := b.size()
+	data := make([]byte, capacity>>3+(capacity&7+7)>>3)
+	for i := range data {
+		data[i] = byte(b.set[i>>3] >> (uint(i) & 7 << 3))
+	}
+	return data
+}
+
+// Bits of b as ceil(Cap()/32) 32-bit words, each 64-bit word split into
+// its low half followed by its high half, for formats built on 32-bit
+// words.
+func (b *BitSet) ToUint32s() []uint32 {
+	capacity := b.size()
+	words := make([]uint32, capacity>>5+(capacity&31+31)>>5)
+	for i := range words {
+		words[i] = uint32(b.set[i>>1] >> (uint(i) & 1 << 5))
+	}
+	return words
+}
+
+// A []bool of length Cap() holding the value of each bit.
+func (b *BitSet) ToBoolSlice() []bool {
+	bits := make([]bool, b.size())
+	for i := range bits {
+		bits[i] = b.set[i>>6]&(1<<(uint(i)&(64-1))) != 0
+	}
+	return bits
+}
+
+// Reconfigure b as an empty set of capacity newCap, reusing the backing
+// array when it is large enough and allocating only when it must grow.
+// Options are kept, but any error recorded for Err is cleared.
+func (b *BitSet) Reset(newCap uint) {
+	b.mustExist()
+	b.ClearErr()
+	n := wordsFor(newCap)
+	if n > cap(b.set) {
+		b.set = make([]uint64, n)
+	} else {
+		b.set = b.set[:n]
+		for i := range b.set {
+			b.set[i] = 0
+		}
+	}
+	b.capacity = newCap
+}
+
+// Bits set in exactly one of b and c, as a new BitSet of the larger
+// capacity. This is Xor under its set-algebra name.
+func (b *BitSet) SymmetricDifference(c *BitSet) *BitSet {
+	return b.Xor(c)
+}
+
+// Number of bits set in exactly one of b and c, without building the
+// result; same as PopCountXor(b, c).
+func (b *BitSet) SymmetricDifferenceCount(c *BitSet) uint {
+	return PopCountXor(b, c)
+}
+
+// Number of set bits in each 64-bit word of b, one entry per word. The
+// final entry covers only the bits below the capacity, which may be fewer
+// than 64.
+func (b *BitSet) WordCounts() []int {
+	set := b.words()
+	counts := make([]int, len(set))
+	for i, word := range set {
+		counts[i] = bits.OnesCount64(word)
+	}
+	return counts
+}
+
+// Index of the lowest set bit, and whether there is one.
+func (b *BitSet) FirstSet() (uint, bool) {
+	return b.NextSet(0)
+}
+
+// Index of the highest set bit, and whether there is one. Words are
+// scanned from the top, and padding beyond the capacity is never set.
+func (b *BitSet) LastSet() (uint, bool) {
+	if e := b.extent(); e > 0 {
+		return e - 1, true
+	}
+	return 0, false
+}
+
+// Lengths of the alternating runs of clear and set bits of b, starting
+// with a clear run that may be empty: bits 3, 4, 5 and 10 encode as
+// [3 3 4 1]. The trailing clear run up to the capacity is left implicit.
+func (b *BitSet) RunLengthEncode() []uint {
+	var runs []uint
+	pos := uint(0)
+	for start, ok := b.NextSet(0); ok; start, ok = b.NextSet(pos) {
+		end, found := b.NextClear(start)
+		if !found {
+			end = b.capacity
+		}
+		runs = append(runs, start-pos, end-start)
+		pos = end
+	}
+	return runs
+}
+
+// Make a BitSet of the given capacity from runs as produced by
+// RunLengthEncode. The runs must sum to at most capacity.
+func RunLengthDecode(capacity uint, runs []uint) (*BitSet, error) {
+	b := New(capacity)
+	pos := uint(0)
+	for k, n := range runs {
+		if n > capacity-pos {
+			return nil, fmt.Errorf("bitset: runs exceed capacity %d at run %d", capacity, k)
+		}
+		if k&1 == 1 {
+			b.SetRange(pos, pos+n)
+		}
+		pos += n
+	}
+	return b, nil
+}
+
+// Complement of b within its capacity as a new BitSet, leaving b
+// unchanged. Padding bits stay clear as in Flip, so the result counts
+// Cap() minus b.Count() bits.
+func (b *BitSet) Complement() *BitSet {
+	c := b.Clone()
+	if c == nil {
+		return New(0)
+	}
+	c.Flip()
+	return c
+}
+
+// Copy the bits of b into c and return how many were copied, which is the
+// smaller of the two capacities; a result below b.Cap() means the copy
+// was truncated. Bits of c at or above that count are cleared.
+func (b *BitSet) Copy(c *BitSet) uint {
+	c.mustExist()
+	count := b.size()
+	if c.capacity < count {
+		count = c.capacity
+	}
+	n := copy(c.set, b.words()[:wordsFor(count)])
+	if r := count & (64 - 1); r != 0 {
+		c.set[n-1] &= 1<<r - 1
+	}
+	for i := n; i < len(c.set); i++ {
+		c.set[i] = 0
+	}
+	return count
+}
+
+// Set difference b minus c as a new BitSet with the capacity of b; bits
+// of c beyond that capacity are irrelevant. Same as AndNot.
+func (b *BitSet) Difference(c *BitSet) *BitSet {
+	return b.AndNot(c)
+}
+
+// Remove from b every bit set in c, in place. Same as AndNotWith.
+func (b *BitSet) DifferenceWith(c *BitSet) {
+	b.AndNotWith(c)
+}
+
+// Mirror the bits of b within its capacity, so that bit i moves to bit
+// Cap()-1-i. Words are swapped end for end and reversed with
+// bits.Reverse64, then shifted down past the padding of the final word.
+func (b *BitSet) Reverse() {
+	b.mustExist()
+	set := b.set
+	for i, j := 0, len(set)-1; i <= j; i, j = i+1, j-1 {
+		set[i], set[j] = bits.Reverse64(set[j]), bits.Reverse64(set[i])
+	}
+	pad := uint(len(set))<<6 - b.capacity
+	if pad == 0 {
+		return
+	}
+	for i := range set {
+		word := set[i] >> pad
+		if i+1 < len(set) {
+			word |= set[i+1] << (64 - pad)
+		}
+		set[i] = word
+	}
+}
+
+// Rotate the bits of b n places toward higher indices within its
+// capacity, so bits pushed past Cap()-1 reappear from bit 0. n is taken
+// modulo the capacity, which need not be a multiple of 64.
+func (b *BitSet) RotateLeft(n uint) {
+	b.mustExist()
+	if b.capacity == 0 {
+		return
+	}
+	if n %= b.capacity; n == 0 {
+		return
+	}
+	wrapped := b.Clone()
+	wrapped.ShiftRight(b.capacity - n)
+	b.ShiftLeft(n)
+	b.OrWith(wrapped)
+}
+
+// Rotate the bits of b n places toward lower indices within its capacity,
+// so bits pushed below 0 reappear from Cap()-1.
+func (b *BitSet) RotateRight(n uint) {
+	b.mustExist()
+	if b.capacity == 0 {
+		return
+	}
+	b.RotateLeft(b.capacity - n%b.capacity)
+}
+
+// Count the set bits by splitting the words into up to workers chunks and
+// popcounting each in its own goroutine. With workers <= 1 this is Count.
+// Starting goroutines costs microseconds, so the serial Count is faster
+// for small sets; the crossover is typically around a million bits.
+func (b *BitSet) CountParallel(workers int) uint {
+	set := b.words()
+	if workers > len(set) {
+		workers = len(set)
+	}
+	if workers <= 1 {
+		return b.Count()
+	}
+	chunk := (len(set) + workers - 1) / workers
+	counts := make([]int, workers)
+	var wg sync.WaitGroup
+	for w := 0; w*chunk < len(set); w++ {
+		part := set[w*chunk:]
+		if len(part) > chunk {
+			part = part[:chunk]
+		}
+		wg.Add(1)
+		go func(w int, part []uint64) {
+			defer wg.Done()
+			cnt := 0
+			for _, word := range part {
+				cnt += bits.OnesCount64(word)
+			}
+			counts[w] = cnt
+		}(w, part)
+	}
+	wg.Wait()
+	total := 0
+	for _, cnt := range counts {
+		total += cnt
+	}
+	return uint(total)
+}
+
+// Number of 64-bit words backing b; Word and SetWord accept indices below
+// it.
+func (b *BitSet) WordCount() int {
+	return len(b.words())
+}
+
+// Check that x indexes one of the words of b.
+func (b *BitSet) checkWord(x uint) {
+	if x >= uint(len(b.words())) {
+		panic(fmt.Sprintf("word index out of range: %v (word count %v)", x, len(b.words())))
+	}
+}
+
+// Word x of b, holding bits 64*x through 64*x+63.
+func (b *BitSet) Word(x uint) uint64 {
+	b.checkWord(x)
+	return b.set[x]
+}
+
+// Replace word x of b with v. Bits of v beyond the capacity are dropped
+// when x is the final word.
+func (b *BitSet) SetWord(x uint, v uint64) {
+	b.mustExist()
+	b.checkWord(x)
+	b.set[x] = v
+	if int(x) == len(b.set)-1 {
+		b.trim()
+	}
+}
+
+// Or the words of src into dst starting at bit pos of dst. dst must have
+// room for every set bit of src.
+func orShifted(dst, src []uint64, pos uint) {
+	for i, word := range src {
+		orWordAt(dst, pos+uint(i)<<6, word)
+	}
+}
+
+// Or word into dst starting at bit pos of dst. dst must have room for
+// every set bit of word.
+func orWordAt(dst []uint64, pos uint, word uint64) {
+	if word == 0 {
+		return
+	}
+	w, off := pos>>6, pos&(64-1)
+	dst[w] |= word << off
+	if off != 0 && word>>(64-off) != 0 {
+		dst[w+1] |= word >> (64 - off)
+	}
+}
+
+// Concatenation of b and c as a new BitSet of capacity
+// b.Cap()+c.Cap(), with bit i of c placed at b.Cap()+i. This is the
+// inverse of Sub: Sub(0, b.Cap()) and Sub(b.Cap(), end) recover the two.
+func (b *BitSet) Append(c *BitSet) *BitSet {
+	r := New(b.size() + c.size())
+	copy(r.set, b.words())
+	orShifted(r.set, c.words(), b.size())
+	return r
+}
+
+// Union c into b, growing b to the capacity of c if that is larger so no
+// bit is lost. OrWith has the same growth semantics; Merge names the
+// accumulate-everything use.
+func (b *BitSet) Merge(c *BitSet) {
+	b.OrWith(c)
+}
+
+// Fraction of the bits below the capacity that are set, from 0.0 for an
+// empty set to 1.0 for a full one. A zero-capacity set has sparsity 0.
+func (b *BitSet) Sparsity() float64 {
+	if b.size() == 0 {
+		return 0
+	}
+	return float64(b.Count()) / float64(b.capacity)
+}
+
+// Test whether the fraction of set bits is below threshold.
+func (b *BitSet) IsSparse(threshold float64) bool {
+	return b.Sparsity() < threshold
+}
+
+// Call fn with the index of each set bit in ascending order, stopping
+// early if fn returns false. This is the callback counterpart of SetBits
+// for toolchains without range-over-func.
+func (b *BitSet) ForEachSet(fn func(i uint) bool) {
+	for i, ok := b.NextSet(0); ok; i, ok = b.NextSet(i + 1) {
+		if !fn(i) {
+			return
+		}
+	}
+}
+
+// Indices set in c but not in b (added) and set in b but not in c
+// (removed), each in ascending order: the Xor of the two split by
+// direction. Missing high bits of the smaller set count as clear. Each
+// slice is allocated once at its exact size.
+func (b *BitSet) ChangedBits(c *BitSet) (added, removed []uint) {
+	added = make([]uint, 0, c.DifferenceCount(b))
+	removed = make([]uint, 0, b.DifferenceCount(c))
+	bw, cw := b.words(), c.words()
+	for x := 0; x < len(bw) || x < len(cw); x++ {
+		var bx, cx uint64
+		if x < len(bw) {
+			bx = bw[x]
+		}
+		if x < len(cw) {
+			cx = cw[x]
+		}
+		for word := cx &^ bx; word != 0; word &= word - 1 {
+			added = append(added, uint(x)<<6+uint(bits.TrailingZeros64(word)))
+		}
+		for word := bx &^ cx; word != 0; word &= word - 1 {
+			removed = append(removed, uint(x)<<6+uint(bits.TrailingZeros64(word)))
+		}
+	}
+	return added, removed
+}
+
+// Format byte opening MarshalBinaryCompressed output, so that later
+// formats can be told apart.
+const formatZeroRuns byte = 1
+
+// Encode b compactly for sets with long stretches of empty words: the
+// format byte, the capacity as a uvarint, then until the words are
+// exhausted, pairs of uvarints counting a run of zero words and the
+// literal words that follow it, each literal as 8 bytes little-endian.
+// MarshalBinary remains the default, fixed-size encoding.
+func (b *BitSet) MarshalBinaryCompressed() ([]byte, error) {
+	set := b.words()
+	data := []byte{formatZeroRuns}
+	data = binary.AppendUvarint(data, uint64(b.size()))
+	for x := 0; x < len(set); {
+		zeros := x
+		for x < len(set) && set[x] == 0 {
+			x++
+		}
+		literals := x
+		for x < len(set) && set[x] != 0 {
+			x++
+		}
+		data = binary.AppendUvarint(data, uint64(literals-zeros))
+		data = binary.AppendUvarint(data, uint64(x-literals))
+		for _, word := range set[literals:x] {
+			data = binary.LittleEndian.AppendUint64(data, word)
+		}
+	}
+	return data, nil
+}
+
+// Decode data written by MarshalBinaryCompressed into b, replacing its
+// contents. A run of zero words costs a byte or two however long it is,
+// so a few bytes could name a set of terabytes; a capacity above
+// MaxDecodeCapacity is therefore rejected before allocating.
+// UnmarshalBinaryCompressedBounded accepts larger sets.
+func (b *BitSet) UnmarshalBinaryCompressed(data []byte) error {
+	return b.UnmarshalBinaryCompressedBounded(data, MaxDecodeCapacity)
+}
+
+// Decode like UnmarshalBinaryCompressed, but reject a capacity above
+// maxBits before allocating, as NewBounded does.
+func (b *BitSet) UnmarshalBinaryCompressedBounded(data []byte, maxBits uint) error {
+	b.mustExist()
+	if len(data) == 0 || data[0] != formatZeroRuns {
+		return fmt.Errorf("bitset: unknown compressed binary format")
+	}
+	data = data[1:]
+	capacity, err := readUvarint(&data)
+	if err != nil {
+		return err
+	}
+	if capacity > uint64(maxBits) {
+		return fmt.Errorf("bitset: capacity %d exceeds bound %d", capacity, maxBits)
+	}
+	c, err := NewSafe(uint(capacity))
+	if err != nil {
+		return err
+	}
+	for x := uint64(0); x < uint64(len(c.set)); {
+		zeros, err := readUvarint(&data)
+		if err != nil {
+			return err
+		}
+		literals, err := readUvarint(&data)
+		if err != nil {
+			return err
+		}
+		if zeros > uint64(len(c.set))-x || literals > uint64(len(c.set))-x-zeros {
+			return fmt.Errorf("bitset: runs exceed the %d words of capacity %d", len(c.set), capacity)
+		}
+		if uint64(len(data)) < 8*literals {
+			return io.ErrUnexpectedEOF
+		}
+		x += zeros
+		for end := x + literals; x < end; x++ {
+			c.set[x] = binary.LittleEndian.Uint64(data)
+			data = data[8:]
+		}
+	}
+	if len(data) != 0 {
+		return fmt.Errorf("bitset: %d trailing bytes after compressed words", len(data))
+	}
+	if n := len(c.set); n > 0 && c.set[n-1]&^c.lastWordMask() != 0 {
+		return fmt.Errorf("bitset: bits set beyond capacity %d", capacity)
+	}
+	b.capacity, b.set = c.capacity, c.set
+	return nil
+}
+
+// Read a uvarint from the front of *data, advancing past it.
+func readUvarint(data *[]byte) (uint64, error) {
+	v, n := binary.Uvarint(*data)
+	if n == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("bitset: uvarint overflows 64 bits")
+	}
+	*data = (*data)[n:]
+	return v, nil
+}
+
+// Set each bit i in [0, capacity) for which pred(i) is true. pred is
+// called once per index, so this is O(capacity) however few bits change.
+func (b *BitSet) SetIf(pred func(i uint) bool) {
+	b.mustExist()
+	for i := uint(0); i < b.capacity; i++ {
+		if pred(i) {
+			b.set[i>>6] |= 1 << (i & (64 - 1))
+		}
+	}
+}
+
+// Clear each bit i in [0, capacity) for which pred(i) is true. Like
+// SetIf, this is O(capacity).
+func (b *BitSet) ClearIf(pred func(i uint) bool) {
+	b.mustExist()
+	for i := uint(0); i < b.capacity; i++ {
+		if pred(i) {
+			b.set[i>>6] &^= 1 << (i & (64 - 1))
+		}
+	}
+}
+
+// Number of leading bits b and c share: the index of the first bit that
+// differs, or the smaller of the two capacities if none differs below
+// it. A set that is a prefix of the other by capacity shares all of its
+// bits.
+func (b *BitSet) CommonPrefixLen(c *BitSet) uint {
+	limit := b.size()
+	if c.size() < limit {
+		limit = c.size()
+	}
+	bw, cw := b.words(), c.words()
+	for x, n := 0, minLen(bw, cw); x < n; x++ {
+		if diff := bw[x] ^ cw[x]; diff != 0 {
+			if i := uint(x)<<6 + uint(bits.TrailingZeros64(diff)); i < limit {
+				return i
+			}
+			break
+		}
+	}
+	return limit
+}
+
+// Drop the words above the highest set bit, reducing the capacity to
+// that bit plus one (0 if empty). Storage and logical capacity stay tied
+// together, so this is Compact under the name callers reach for after
+// clearing a high region: bit values are preserved, and indices past the
+// new capacity are out of range.
+func (b *BitSet) TrimToFit() {
+	b.Compact()
+}
+
+// Render b as its capacity and set indices, like "cap=100;3,7,99". Unlike
+// String the list is never elided, so UnmarshalText restores b exactly.
+// Implements encoding.TextMarshaler.
+func (b *BitSet) MarshalText() ([]byte, error) {
+	text := append([]byte("cap="), strconv.FormatUint(uint64(b.size()), 10)...)
+	text = append(text, ';')
+	for i, ok := b.NextSet(0); ok; i, ok = b.NextSet(i + 1) {
+		if text[len(text)-1] != ';' {
+			text = append(text, ',')
+		}
+		text = strconv.AppendUint(text, uint64(i), 10)
+	}
+	return text, nil
+}
+
+// Largest capacity the decoders that cannot take a bound accept, such as
+// UnmarshalText: 2^30 bits, or 128 MiB of words. Their input is a few
+// bytes that name a capacity, which must not be able to demand more
+// memory than a typical process can spare. The Bounded variants take
+// their own ceiling.
+const MaxDecodeCapacity = 1 << 30
+
+// Parse text written by MarshalText into b, replacing its contents. Every
+// index must be a decimal below the capacity, and a capacity above
+// MaxDecodeCapacity is rejected, so text from flags or env cannot demand
+// unbounded memory; UnmarshalTextBounded accepts larger sets. Implements
+// encoding.TextUnmarshaler.
+func (b *BitSet) UnmarshalText(text []byte) error {
+	return b.UnmarshalTextBounded(text, MaxDecodeCapacity)
+}
+
+// Parse like UnmarshalText, but reject a capacity above maxBits before
+// allocating, as NewBounded does.
+func (b *BitSet) UnmarshalTextBounded(text []byte, maxBits uint) error {
+	b.mustExist()
+	s := string(text)
+	if !strings.HasPrefix(s, "cap=") {
+		return fmt.Errorf("bitset: text %q does not start with cap=", s)
+	}
+	header, list, ok := strings.Cut(s[len("cap="):], ";")
+	if !ok {
+		return fmt.Errorf("bitset: text %q lacks ; after the capacity", s)
+	}
+	capacity, err := strconv.ParseUint(header, 10, bits.UintSize)
+	if err != nil {
+		return fmt.Errorf("bitset: invalid capacity %q", header)
+	}
+	c, err := NewBounded(uint(capacity), maxBits)
+	if err != nil {
+		return err
+	}
+	if list != "" {
+		for _, token := range strings.Split(list, ",") {
+			i, err := strconv.ParseUint(token, 10, bits.UintSize)
+			if err != nil {
+				return fmt.Errorf("bitset: invalid index %q", token)
+			}
+			if err := c.SetBitE(uint(i)); err != nil {
+				return err
+			}
+		}
+	}
+	b.capacity, b.set = c.capacity, c.set
+	return nil
+}
+
+// And every word of b with mask, so bit i survives only where bit i%64
+// of mask is set.
+func (b *BitSet) AndScalar(mask uint64) {
+	b.mustExist()
+	for i := range b.set {
+		b.set[i] &= mask
+	}
+}
+
+// Or mask into every word of b, within the capacity. OrScalar(0x5555...)
+// sets every even-numbered bit.
+func (b *BitSet) OrScalar(mask uint64) {
+	b.mustExist()
+	for i := range b.set {
+		b.set[i] |= mask
+	}
+	b.trim()
+}
+
+// Xor mask into every word of b, within the capacity.
+// XorScalar(^uint64(0)) is Flip.
+func (b *BitSet) XorScalar(mask uint64) {
+	b.mustExist()
+	for i := range b.set {
+		b.set[i] ^= mask
+	}
+	b.trim()
+}
+
+// Set in b each index read from r as a uvarint, as written by
+// binary.AppendUvarint, until EOF. An index outside the capacity stops
+// the read with ErrOutOfRange rather than growing b, so a corrupt stream
+// cannot force a huge allocation; bits set before it are kept. A varint
+// cut short at EOF yields io.ErrUnexpectedEOF. r is read through a
+// bufio.Reader unless it is already an io.ByteReader.
+func (b *BitSet) OrIndicesFrom(r io.Reader) error {
+	b.mustExist()
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	for {
+		i, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if i >= uint64(b.capacity) {
+			return ErrOutOfRange{uint(i), b.capacity}
+		}
+		b.set[i>>6] |= 1 << (i & (64 - 1))
+	}
+}
+
+// Number of bit positions at which b and c differ, with the shorter set
+// zero-extended: PopCountXor under the name used for comparing hash
+// signatures. No allocation is made.
+func (b *BitSet) HammingDistance(c *BitSet) uint {
+	return PopCountXor(b, c)
+}
+
+// Test whether the first n bits of b and c match, whatever lies beyond.
+// n is not clamped: like the other range methods, this panics if n
+// exceeds either capacity.
+func (b *BitSet) EqualPrefix(c *BitSet, n uint) bool {
+	b.checkRange(0, n)
+	c.checkRange(0, n)
+	full := n >> 6
+	for x := uint(0); x < full; x++ {
+		if b.set[x] != c.set[x] {
+			return false
+		}
+	}
+	if r := n & (64 - 1); r != 0 {
+		return (b.set[full]^c.set[full])&(1<<r-1) == 0
+	}
+	return true
+}
+
+// OR-pool b down to a new BitSet of capacity newCap: output bit j is set
+// if any bit in its group of b is. With C the capacity of b, group j is
+// [ceil(j*C/newCap), ceil((j+1)*C/newCap)), so when newCap does not divide
+// C the groups differ in size by at most one bit, spread evenly. Panics
+// if newCap exceeds the capacity of b.
+func (b *BitSet) Downsample(newCap uint) *BitSet {
+	capacity := b.size()
+	if newCap > capacity {
+		panic(fmt.Sprintf("bitset: cannot downsample capacity %v to larger %v", capacity, newCap))
+	}
+	c := New(newCap)
+	if newCap == 0 {
+		return c
+	}
+	for i, ok := b.NextSet(0); ok; i, ok = b.NextSet(i) {
+		hi, lo := bits.Mul(i, newCap)
+		j, _ := bits.Div(hi, lo, capacity)
+		c.set[j>>6] |= 1 << (j & (64 - 1))
+		// skip to the start of group j+1
+		hi, lo = bits.Mul(j+1, capacity)
+		next, rem := bits.Div(hi, lo, newCap)
+		if rem != 0 {
+			next++
+		}
+		i = next
+	}
+	return c
+}
+
+// Encode the set bits of b as uvarints: the lowest index, then the gap
+// from each set bit to the next. The capacity is not recorded, so it is
+// passed to UnmarshalDeltaVarint. For a few thousand bits spread over a
+// large capacity this is far smaller than MarshalBinary.
+func (b *BitSet) MarshalDeltaVarint() []byte {
+	var data []byte
+	prev := uint(0)
+	for i, ok := b.NextSet(0); ok; i, ok = b.NextSet(i + 1) {
+		data = binary.AppendUvarint(data, uint64(i-prev))
+		prev = i
+	}
+	return data
+}
+
+// Make a BitSet of the given capacity from data written by
+// MarshalDeltaVarint. Every index must be below the capacity and every
+// gap after the first index non-zero, so each set has one encoding. The
+// capacity comes from the caller, not from data, and is allocated in
+// full as by NewSafe, so bound it first if it is untrusted.
+func UnmarshalDeltaVarint(capacity uint, data []byte) (*BitSet, error) {
+	b, err := NewSafe(capacity)
+	if err != nil {
+		return nil, err
+	}
+	i := uint64(0)
+	for first := true; len(data) > 0; first = false {
+		gap, err := readUvarint(&data)
+		if err != nil {
+			return nil, err
+		}
+		if gap == 0 && !first {
+			return nil, fmt.Errorf("bitset: zero gap after index %d", i)
+		}
+		if gap >= uint64(capacity)-i {
+			return nil, fmt.Errorf("bitset: index beyond capacity %d after %d", capacity, i)
+		}
+		i += gap
+		b.set[i>>6] |= 1 << (i & (64 - 1))
+	}
+	return b, nil
+}
+
+// Set exactly the lowest n bits of b and clear the rest, in one pass of
+// whole-word stores. n is clamped to the capacity.
+func (b *BitSet) FillLow(n uint) {
+	b.mustExist()
+	if n > b.capacity {
+		n = b.capacity
+	}
+	full := n >> 6
+	for x := range b.set {
+		switch {
+		case uint(x) < full:
+			b.set[x] = ^uint64(0)
+		case uint(x) == full:
+			b.set[x] = 1<<(n&(64-1)) - 1
+		default:
+			b.set[x] = 0
+		}
+	}
+}
+
+// Test whether the set bits of b are exactly [0, n) for some n, and
+// return that n: the first clear bit must have no set bit at or above it.
+// An empty set is the empty prefix, (0, true).
+func (b *BitSet) IsContiguousFromZero() (uint, bool) {
+	n, ok := b.NextClear(0)
+	if !ok {
+		return b.size(), true
+	}
+	if _, ok := b.NextSet(n); ok {
+		return 0, false
+	}
+	return n, true
+}
+
+// Morton code of x and y: a BitSet of capacity x.Cap()+y.Cap() whose bit
+// 2i is bit i of x and whose bit 2i+1 is bit i of y. Panics unless the
+// capacities are equal, since there is no position for an unpaired bit.
+func Interleave(x, y *BitSet) *BitSet {
+	if x.size() != y.size() {
+		panic(fmt.Sprintf("bitset: cannot interleave capacities %v and %v", x.size(), y.size()))
+	}
+	z := New(2 * x.size())
+	xw, yw := x.words(), y.words()
+	for k := range z.set {
+		shift := uint(k) & 1 << 5
+		z.set[k] = spreadBits(uint32(xw[k>>1]>>shift)) | spreadBits(uint32(yw[k>>1]>>shift))<<1
+	}
+	return z
+}
+
+// Inverse of Interleave: split z into its even-numbered bits x and its
+// odd-numbered bits y, each of capacity z.Cap()/2. Panics if the
+// capacity of z is odd.
+func Deinterleave(z *BitSet) (x, y *BitSet) {
+	if z.size()&1 != 0 {
+		panic(fmt.Sprintf("bitset: cannot deinterleave odd capacity %v", z.size()))
+	}
+	x, y = New(z.size()/2), New(z.size()/2)
+	for k, word := range z.words() {
+		shift := uint(k) & 1 << 5
+		x.set[k>>1] |= uint64(gatherBits(word)) << shift
+		y.set[k>>1] |= uint64(gatherBits(word>>1)) << shift
+	}
+	return x, y
+}
+
+// Move bit i of v to bit 2i.
+func spreadBits(v uint32) uint64 {
+	w := uint64(v)
+	w = (w | w<<16) & 0x0000ffff0000ffff
+	w = (w | w<<8) & 0x00ff00ff00ff00ff
+	w = (w | w<<4) & 0x0f0f0f0f0f0f0f0f
+	w = (w | w<<2) & 0x3333333333333333
+	w = (w | w<<1) & 0x5555555555555555
+	return w
+}
+
+// Move bit 2i of w to bit i, dropping the odd-numbered bits.
+func gatherBits(w uint64) uint32 {
+	w &= 0x5555555555555555
+	w = (w | w>>1) & 0x3333333333333333
+	w = (w | w>>2) & 0x0f0f0f0f0f0f0f0f
+	w = (w | w>>4) & 0x00ff00ff00ff00ff
+	w = (w | w>>8) & 0x0000ffff0000ffff
+	w = (w | w>>16) & 0x00000000ffffffff
+	return uint32(w)
+}
+
+// Bitwise vote across sets: bit i of the result is set iff more than half
+// of the inputs have bit i set. With an even number of inputs a tie of
+// exactly half is not a majority and leaves the bit clear. All inputs
+// must share one capacity, and there must be at least one.
+func Majority(sets []*BitSet) (*BitSet, error) {
+	if len(sets) == 0 {
+		return nil, fmt.Errorf("bitset: majority of no sets")
+	}
+	for _, s := range sets[1:] {
+		if s.size() != sets[0].size() {
+			return nil, fmt.Errorf("bitset: majority of capacities %d and %d", sets[0].size(), s.size())
+		}
+	}
+	m := New(sets[0].size())
+	var votes [64]int
+	for x := range m.set {
+		votes = [64]int{}
+		for _, s := range sets {
+			for word := s.set[x]; word != 0; word &= word - 1 {
+				votes[bits.TrailingZeros64(word)]++
+			}
+		}
+		for i, n := range votes {
+			if 2*n > len(sets) {
+				m.set[x] |= 1 << uint(i)
+			}
+		}
+	}
+	return m, nil
+}
+
+// Indices of the from-th through to-th set bits, counting from 0 as in
+// Select and including both ends: one page of the members in ascending
+// order. to is clamped to the last set bit, and the result is empty if
+// from is beyond it or to < from. Words are skipped by popcount up to
+// from and the scan stops once the to-th bit is reached.
+func (b *BitSet) SelectRange(from, to uint) []uint {
+	indices := []uint{}
+	if to < from {
+		return indices
+	}
+	rank := uint(0)
+	for x, word := range b.words() {
+		cnt := uint(bits.OnesCount64(word))
+		if rank+cnt <= from {
+			rank += cnt
+			continue
+		}
+		for ; word != 0; word &= word - 1 {
+			if rank >= from {
+				indices = append(indices, uint(x)<<6+uint(bits.TrailingZeros64(word)))
+			}
+			if rank == to {
+				return indices
+			}
+			rank++
+		}
+	}
+	return indices
+}
+
+// Mask of the low count bits, count <= 64, panicking otherwise.
+func fieldMask(count uint) uint64 {
+	if count > 64 {
+		panic(fmt.Sprintf("bitset: field of %v bits exceeds 64", count))
+	}
+	if count == 64 {
+		return ^uint64(0)
+	}
+	return 1<<count - 1
+}
+
+// The count bits of b from start, bit start becoming bit 0 of the result,
+// reading across a word boundary as needed. Panics if count exceeds 64 or
+// the field extends beyond the capacity.
+func (b *BitSet) GetBits(start, count uint) uint64 {
+	mask := fieldMask(count)
+	b.checkRange(start, start+count)
+	if count == 0 {
+		return 0
+	}
+	x, off := start>>6, start&(64-1)
+	v := b.set[x] >> off
+	if off+count > 64 {
+		v |= b.set[x+1] << (64 - off)
+	}
+	return v & mask
+}
+
+// Store the low count bits of value into b from start, the inverse of
+// GetBits; higher bits of value are ignored. Panics as GetBits does.
+func (b *BitSet) PutBits(start, count uint, value uint64) {
+	b.mustExist()
+	mask := fieldMask(count)
+	b.checkRange(start, start+count)
+	if count == 0 {
+		return
+	}
+	x, off := start>>6, start&(64-1)
+	value &= mask
+	b.set[x] = b.set[x]&^(mask<<off) | value<<off
+	if off+count > 64 {
+		b.set[x+1] = b.set[x+1]&^(mask>>(64-off)) | value>>(64-off)
+	}
+}
+
+// Set bits grouped as in Roaring bitmaps: each index i goes to the bucket
+// keyed by its high 16 bits, i>>16, as its low 16 bits, i&0xffff, so
+// bucket k with offsets lo holds the indices k<<16|lo. Offsets within a
+// bucket ascend, and empty buckets are absent. Roaring indices are 32-bit,
+// so this panics if a bit at or above 2^32 is set.
+func (b *BitSet) HighLowSplit() map[uint16][]uint16 {
+	if uint64(b.extent()) > 1<<32 {
+		panic(fmt.Sprintf("bitset: set bit %v does not fit in 32 bits", b.extent()-1))
+	}
+	buckets := make(map[uint16][]uint16)
+	for i, ok := b.NextSet(0); ok; i, ok = b.NextSet(i + 1) {
+		buckets[uint16(i>>16)] = append(buckets[uint16(i>>16)], uint16(i))
+	}
+	return buckets
+}
+
+// Verify the internal invariants every method relies on: the set holds
+// exactly ceil(Cap()/64) words and no bit beyond the capacity is set in
+// the last of them. A nil BitSet is valid. Meant for tests and fuzz
+// targets; a correct program never sees an error.
+func (b *BitSet) CheckInvariants() error {
+	if b == nil {
+		return nil
+	}
+	if len(b.set) != wordsFor(b.capacity) {
+		return fmt.Errorf("bitset: capacity %d needs %d words, but has %d", b.capacity, wordsFor(b.capacity), len(b.set))
+	}
+	if n := len(b.set); n > 0 && b.set[n-1]&^b.lastWordMask() != 0 {
+		return fmt.Errorf("bitset: padding bits %#x set beyond capacity %d", b.set[n-1]&^b.lastWordMask(), b.capacity)
+	}
+	return nil
+}
+
+// Number of bits that OrWith(c) would newly set in b: the popcount of
+// c &^ b, which is c.DifferenceCount(b). Bits of c beyond the capacity
+// of b count as new, since OrWith grows b to keep them. Nothing is
+// allocated.
+func (b *BitSet) NewBitsFrom(c *BitSet) uint {
+	return c.DifferenceCount(b)
+}
+
+// length bits of b from start as a new BitSet of capacity length,
+// treating b as a ring: bit k of the result is bit (start+k) mod Cap() of
+// b, so a window running past the capacity continues from bit 0. Unlike
+// Sub nothing is out of range, except that a non-empty window of a
+// zero-capacity set panics.
+func (b *BitSet) SubWrapped(start, length uint) *BitSet {
+	c := New(length)
+	if length == 0 {
+		return c
+	}
+	capacity := b.size()
+	if capacity == 0 {
+		panic("bitset: cannot take a wrapped window of a zero-capacity set")
+	}
+	pos := start % capacity
+	for done := uint(0); done < length; pos = 0 {
+		chunk := capacity - pos
+		if chunk > length-done {
+			chunk = length - done
+		}
+		for k := uint(0); k < chunk; k += 64 {
+			word := b.wordAt(pos + k)
+			if n := chunk - k; n < 64 {
+				word &= 1<<n - 1
+			}
+			orWordAt(c.set, done+k, word)
+		}
+		done += chunk
+	}
+	return c
+}
+
+// Call fn once per word of b in order with the index of the word's bit 0,
+// for kernels that work 64 bits at a time. Bits beyond the capacity in
+// the final word are always zero, so no word needs masking.
+func (b *BitSet) ForEachWord(fn func(base uint, word uint64)) {
+	for x, word := range b.words() {
+		fn(uint(x)<<6, word)
+	}
+}
+
+// Clear in b every bit that is set in c, as AndNotWith does, and return
+// how many bits of b went from 1 to 0: the IntersectionCount of b and c
+// before the call, counted in the same pass.
+func (b *BitSet) SubtractCounting(c *BitSet) uint {
+	b.mustExist()
+	cw := c.words()
+	cnt := 0
+	for i := 0; i < minLen(b.set, cw); i++ {
+		cnt += bits.OnesCount64(b.set[i] & cw[i])
+		b.set[i] &^= cw[i]
+	}
+	return uint(cnt)
+}
+
+// Set in b each index read from r, one decimal per line, and return how
+// many indices were read. Surrounding whitespace, including a CR before
+// the newline, is ignored and blank lines are skipped. A line that is
+// not a decimal, or names an index outside the capacity, stops the read
+// with an error giving its line number; indices before it stay set.
+func (b *BitSet) SetFromLines(r io.Reader) (int, error) {
+	b.mustExist()
+	s := bufio.NewScanner(r)
+	n := 0
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" {
+			continue
+		}
+		i, err := strconv.ParseUint(text, 10, bits.UintSize)
+		if err != nil {
+			return n, fmt.Errorf("bitset: line %d: invalid index %q", line, text)
+		}
+		if err := b.check(uint(i)); err != nil {
+			return n, fmt.Errorf("bitset: line %d: %v", line, err)
+		}
+		b.set[i>>6] |= 1 << (i & (64 - 1))
+		n++
+	}
+	return n, s.Err()
+}
+
+// Intersection of all the sets as one new BitSet, each word computed in a
+// single pass over the inputs. The capacity is the smallest of theirs,
+// since no bit beyond it can be in every set; And instead keeps the
+// receiver's. With no sets the result is nil, the empty set.
+func IntersectAll(sets ...*BitSet) *BitSet {
+	if len(sets) == 0 {
+		return nil
+	}
+	capacity := sets[0].size()
+	for _, s := range sets[1:] {
+		if s.size() < capacity {
+			capacity = s.size()
+		}
+	}
+	r := New(capacity)
+	for x := range r.set {
+		word := ^uint64(0)
+		for _, s := range sets {
+			word &= s.set[x]
+		}
+		r.set[x] = word
+	}
+	return r
+}
+
+// Union of all the sets as one new BitSet, each word computed in a single
+// pass over the inputs. The capacity is the largest of theirs, as with a
+// chain of Or. With no sets the result is nil, the empty set.
+func UnionAll(sets ...*BitSet) *BitSet {
+	if len(sets) == 0 {
+		return nil
+	}
+	capacity := uint(0)
+	for _, s := range sets {
+		capacity = maxCap(capacity, s.size())
+	}
+	r := New(capacity)
+	for x := range r.set {
+		word := uint64(0)
+		for _, s := range sets {
+			if sw := s.words(); x < len(sw) {
+				word |= sw[x]
+			}
+		}
+		r.set[x] = word
+	}
+	return r
+}
+
+// Copy length bits of src from srcPos into dst from dstPos, leaving the
+// other bits of dst alone. Like memmove, overlapping ranges of one set are
+// handled by copying in the direction that reads each bit before it is
+// overwritten. Returns an error, changing nothing, if either range
+// extends beyond its set's capacity.
+func CopyBits(dst *BitSet, dstPos uint, src *BitSet, srcPos, length uint) error {
+	dst.mustExist()
+	if length > src.size() || srcPos > src.size()-length {
+		return fmt.Errorf("bitset: %d bits from %d exceed source capacity %d", length, srcPos, src.size())
+	}
+	if length > dst.size() || dstPos > dst.size()-length {
+		return fmt.Errorf("bitset: %d bits from %d exceed destination capacity %d", length, dstPos, dst.size())
+	}
+	if dst == src && dstPos > srcPos {
+		// copy from the top down so the source is read before it is overwritten
+		for k := length; k > 0; {
+			n := k
+			if n > 64 {
+				n = 64
+			}
+			k -= n
+			dst.PutBits(dstPos+k, n, src.GetBits(srcPos+k, n))
+		}
+		return nil
+	}
+	for k := uint(0); k < length; {
+		n := length - k
+		if n > 64 {
+			n = 64
+		}
+		dst.PutBits(dstPos+k, n, src.GetBits(srcPos+k, n))
+		k += n
+	}
+	return nil
+}
@@ -7,6 +7,18 @@
 package bitset
 
 import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -195,3 +207,3103 @@ func TestMap(t *testing.T) {
 		t.Errorf("John didn't come: %d", there)
 	}
 }
+
+// random set of the given capacity with roughly one bit in every `every` set
+func randomSet(r *rand.Rand, capacity uint, every int) *BitSet {
+	v := New(capacity)
+	for i := uint(0); i < capacity; i++ {
+		if r.Intn(every) == 0 {
+			v.SetBit(i)
+		}
+	}
+	return v
+}
+
+// bit i of v, reading bits beyond capacity (or of nil) as clear
+func bitOrZero(v *BitSet, i uint) bool {
+	return v != nil && i < v.Cap() && v.Bit(i)
+}
+
+func TestEqu(t *testing.T) {
+	a := New(100)
+	b := New(100)
+	a.SetBit(99)
+	if a.Equ(b) {
+		t.Errorf("Sets with different bits should not be equal")
+	}
+	b.SetBit(99)
+	if !a.Equ(b) {
+		t.Errorf("Sets with the same bits should be equal")
+	}
+	if a.Equ(New(128)) || New(100).Equ(New(128)) {
+		t.Errorf("Sets with different capacities should not be equal")
+	}
+	var n *BitSet
+	if !n.Equ(New(0)) || !New(0).Equ(nil) || a.Equ(nil) {
+		t.Errorf("A nil set should only equal an empty set of capacity 0")
+	}
+}
+
+func TestBinaryOps(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	caps := []uint{0, 1, 63, 64, 65, 130, 200}
+	for _, ca := range caps {
+		for _, cb := range caps {
+			a := randomSet(r, ca, 3)
+			b := randomSet(r, cb, 3)
+			ops := []struct {
+				name     string
+				got      *BitSet
+				capacity uint
+				f        func(x, y bool) bool
+			}{
+				{"And", a.And(b), ca, func(x, y bool) bool { return x && y }},
+				{"Or", a.Or(b), maxCap(ca, cb), func(x, y bool) bool { return x || y }},
+				{"Xor", a.Xor(b), maxCap(ca, cb), func(x, y bool) bool { return x != y }},
+				{"AndNot", a.AndNot(b), ca, func(x, y bool) bool { return x && !y }},
+			}
+			for _, op := range ops {
+				if op.got.Cap() != op.capacity {
+					t.Errorf("%s(%d, %d) has capacity %d, but it should be %d", op.name, ca, cb, op.got.Cap(), op.capacity)
+					continue
+				}
+				cnt := uint(0)
+				for i := uint(0); i < op.capacity; i++ {
+					want := op.f(bitOrZero(a, i), bitOrZero(b, i))
+					if op.got.Bit(i) != want {
+						t.Errorf("%s(%d, %d): bit %d is %v, but it should be %v", op.name, ca, cb, i, op.got.Bit(i), want)
+						break
+					}
+					if want {
+						cnt++
+					}
+				}
+				if op.got.Count() != cnt {
+					t.Errorf("%s(%d, %d) counts %d bits, but it should be %d", op.name, ca, cb, op.got.Count(), cnt)
+				}
+			}
+		}
+	}
+}
+
+func TestBinaryOpsNil(t *testing.T) {
+	a := New(100)
+	a.SetBit(3)
+	if a.And(nil).Count() != 0 || a.AndNot(nil).Count() != 1 {
+		t.Errorf("A nil operand should act as an empty set")
+	}
+	if !a.Or(nil).Equ(a) || !a.Xor(nil).Equ(a) {
+		t.Errorf("A nil operand should act as an empty set")
+	}
+}
+
+func TestInPlaceOps(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	caps := []uint{0, 1, 63, 64, 65, 130, 200}
+	for _, ca := range caps {
+		for _, cb := range caps {
+			a := randomSet(r, ca, 2)
+			b := randomSet(r, cb, 2)
+			ops := []struct {
+				name     string
+				apply    func(v *BitSet)
+				capacity uint
+				f        func(x, y bool) bool
+			}{
+				{"AndWith", func(v *BitSet) { v.AndWith(b) }, ca, func(x, y bool) bool { return x && y }},
+				{"OrWith", func(v *BitSet) { v.OrWith(b) }, maxCap(ca, cb), func(x, y bool) bool { return x || y }},
+				{"XorWith", func(v *BitSet) { v.XorWith(b) }, maxCap(ca, cb), func(x, y bool) bool { return x != y }},
+				{"AndNotWith", func(v *BitSet) { v.AndNotWith(b) }, ca, func(x, y bool) bool { return x && !y }},
+			}
+			for _, op := range ops {
+				v := a.Clone()
+				op.apply(v)
+				if v.Cap() != op.capacity {
+					t.Errorf("%s(%d, %d) left capacity %d, but it should be %d", op.name, ca, cb, v.Cap(), op.capacity)
+					continue
+				}
+				cnt := uint(0)
+				for i := uint(0); i < op.capacity; i++ {
+					if op.f(bitOrZero(a, i), bitOrZero(b, i)) {
+						cnt++
+					}
+				}
+				if v.Count() != cnt {
+					t.Errorf("%s(%d, %d) counts %d bits, but it should be %d", op.name, ca, cb, v.Count(), cnt)
+				}
+			}
+		}
+	}
+}
+
+func TestOrWithGrows(t *testing.T) {
+	a := New(10)
+	b := New(300)
+	b.SetBit(299)
+	a.OrWith(b)
+	if a.Cap() != 300 || !a.Bit(299) {
+		t.Errorf("OrWith should grow the receiver to hold bit 299")
+	}
+}
+
+func TestNextSet(t *testing.T) {
+	v := New(1000000)
+	want := []uint{0, 63, 64, 1000, 999999}
+	for _, i := range want {
+		v.SetBit(i)
+	}
+	var got []uint
+	for i, ok := v.NextSet(0); ok; i, ok = v.NextSet(i + 1) {
+		got = append(got, i)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("NextSet found %v, but it should find %v", got, want)
+	}
+	for k := range want {
+		if got[k] != want[k] {
+			t.Errorf("NextSet found %v, but it should find %v", got, want)
+			break
+		}
+	}
+	if i, ok := v.NextSet(65); !ok || i != 1000 {
+		t.Errorf("NextSet(65) returned %d, %v, but it should be 1000, true", i, ok)
+	}
+	if _, ok := v.NextSet(1000000); ok {
+		t.Errorf("NextSet at capacity should find nothing")
+	}
+	if _, ok := v.NextSet(5000000); ok {
+		t.Errorf("NextSet beyond capacity should find nothing")
+	}
+	if _, ok := New(100).NextSet(0); ok {
+		t.Errorf("NextSet on an empty set should find nothing")
+	}
+}
+
+func TestNextClear(t *testing.T) {
+	v := New(130)
+	for i := uint(0); i < 130; i++ {
+		v.SetBit(i)
+	}
+	if i, ok := v.NextClear(0); ok {
+		t.Errorf("NextClear on a full set found %d, but it should find nothing", i)
+	}
+	v.ClearBit(70)
+	if i, ok := v.NextClear(0); !ok || i != 70 {
+		t.Errorf("NextClear(0) returned %d, %v, but it should be 70, true", i, ok)
+	}
+	if i, ok := v.NextClear(71); ok || i != 0 {
+		t.Errorf("NextClear(71) returned %d, %v in the partial word padding, but it should be 0, false", i, ok)
+	}
+	v.SetBit(70)
+	if i, ok := v.NextClear(0); ok || i != 0 {
+		t.Errorf("NextClear(0) on a full set returned %d, %v, but it should be 0, false like NextSet", i, ok)
+	}
+	v.ClearBit(70)
+	v.ClearBit(129)
+	if i, ok := v.NextClear(71); !ok || i != 129 {
+		t.Errorf("NextClear(71) returned %d, %v, but it should be 129, true", i, ok)
+	}
+	if _, ok := v.NextClear(130); ok {
+		t.Errorf("NextClear at capacity should find nothing")
+	}
+	w := New(64)
+	for i := uint(0); i < 64; i++ {
+		w.SetBit(i)
+	}
+	if _, ok := w.NextClear(3); ok {
+		t.Errorf("NextClear on a full single word should find nothing")
+	}
+	if i, ok := New(10).NextClear(4); !ok || i != 4 {
+		t.Errorf("NextClear(4) on an empty set returned %d, %v, but it should be 4, true", i, ok)
+	}
+}
+
+func TestMarshalBinary(t *testing.T) {
+	r := rand.New(rand.NewSource(5))
+	for _, capacity := range []uint{0, 1, 63, 64, 65, 100, 1000} {
+		v := randomSet(r, capacity, 3)
+		data, err := v.MarshalBinary()
+		if err != nil {
+			t.Fatalf("MarshalBinary failed: %v", err)
+		}
+		var w BitSet
+		if err := w.UnmarshalBinary(data); err != nil {
+			t.Fatalf("UnmarshalBinary failed: %v", err)
+		}
+		if !w.Equ(v) {
+			t.Errorf("Round trip of capacity %d did not preserve the set", capacity)
+		}
+	}
+}
+
+func TestUnmarshalBinaryBad(t *testing.T) {
+	v := New(100)
+	v.SetBit(99)
+	data, _ := v.MarshalBinary()
+	var w BitSet
+	for _, bad := range [][]byte{nil, data[:7], data[:8], data[:len(data)-1], append(data, 0)} {
+		if err := w.UnmarshalBinary(bad); err == nil {
+			t.Errorf("UnmarshalBinary of %d bytes should have failed", len(bad))
+		}
+	}
+	data[len(data)-1] = 0xff
+	if err := w.UnmarshalBinary(data); err == nil {
+		t.Errorf("UnmarshalBinary with bits beyond capacity should have failed")
+	}
+}
+
+func TestUnmarshalBinaryHugeCapacity(t *testing.T) {
+	data := []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	var w BitSet
+	if err := w.UnmarshalBinary(data); err == nil {
+		t.Errorf("UnmarshalBinary of a huge capacity without words should have failed")
+	}
+}
+
+// reader returning at most one byte per Read call
+type oneByteReader struct {
+	r io.Reader
+}
+
+func (o oneByteReader) Read(p []byte) (int, error) {
+	if len(p) > 1 {
+		p = p[:1]
+	}
+	return o.r.Read(p)
+}
+
+func TestWriteToReadFrom(t *testing.T) {
+	r := rand.New(rand.NewSource(6))
+	for _, capacity := range []uint{0, 1, 65, 1000, 64*streamWords + 1, 3 * 64 * streamWords} {
+		v := randomSet(r, capacity, 5)
+		var buf bytes.Buffer
+		n, err := v.WriteTo(&buf)
+		if err != nil {
+			t.Fatalf("WriteTo failed: %v", err)
+		}
+		want, _ := v.MarshalBinary()
+		if n != int64(len(want)) || !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("WriteTo of capacity %d should match MarshalBinary", capacity)
+		}
+		var w BitSet
+		m, err := w.ReadFrom(oneByteReader{&buf})
+		if err != nil {
+			t.Fatalf("ReadFrom failed: %v", err)
+		}
+		if m != n || !w.Equ(v) {
+			t.Errorf("Round trip of capacity %d read %d of %d bytes", capacity, m, n)
+		}
+	}
+}
+
+func TestReadFromTruncated(t *testing.T) {
+	v := New(1000)
+	v.SetBit(999)
+	data, _ := v.MarshalBinary()
+	for _, size := range []int{0, 5, 8, 20, len(data) - 1} {
+		var w BitSet
+		if _, err := w.ReadFrom(bytes.NewReader(data[:size])); err != io.ErrUnexpectedEOF {
+			t.Errorf("ReadFrom of %d bytes returned %v, but it should be io.ErrUnexpectedEOF", size, err)
+		}
+	}
+	// a bare header claiming a huge capacity must not be trusted with an
+	// allocation before any words arrive
+	for _, capacity := range []uint64{1 << 40, uint64(MaxCapacity)} {
+		header := binary.LittleEndian.AppendUint64(nil, capacity)
+		var w BitSet
+		if _, err := w.ReadFrom(bytes.NewReader(header)); err != io.ErrUnexpectedEOF {
+			t.Errorf("ReadFrom of a bare header for capacity %d returned %v, but it should be io.ErrUnexpectedEOF", capacity, err)
+		}
+	}
+	for _, capacity := range []uint64{uint64(MaxCapacity) + 1, 1 << 62, ^uint64(0)} {
+		if uint64(MaxCapacity) == ^uint64(0) {
+			break
+		}
+		header := binary.LittleEndian.AppendUint64(nil, capacity)
+		var w BitSet
+		if _, err := w.ReadFrom(bytes.NewReader(header)); err == nil || err == io.ErrUnexpectedEOF {
+			t.Errorf("ReadFrom of a header for capacity %d should reject it, got %v", capacity, err)
+		}
+	}
+}
+
+func TestReadFromAllocs(t *testing.T) {
+	for _, capacity := range []uint{0, 10000000, 64*readWordsUpfront + 1000} {
+		v := New(capacity)
+		for i := uint(0); i < capacity; i += 7 {
+			v.SetBit(i)
+		}
+		data, _ := v.MarshalBinary()
+		var w BitSet
+		if _, err := w.ReadFrom(bytes.NewReader(data)); err != nil || !w.Equ(v) {
+			t.Fatalf("ReadFrom of capacity %d failed: %v", capacity, err)
+		}
+		if cap(w.set) != len(w.set) {
+			t.Errorf("ReadFrom of capacity %d should size its words exactly, but has %d of room for %d", capacity, len(w.set), cap(w.set))
+		}
+		if capacity > 64*readWordsUpfront {
+			continue
+		}
+		// the words themselves plus the read buffer
+		if n := testing.AllocsPerRun(5, func() { w.ReadFrom(bytes.NewReader(data)) }); n > 3 {
+			t.Errorf("ReadFrom of capacity %d made %v allocations, want at most 3", capacity, n)
+		}
+	}
+}
+
+func BenchmarkWriteTo(b *testing.B) {
+	v := New(10000000)
+	for i := uint(0); i < v.Cap(); i += 7 {
+		v.SetBit(i)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		v.WriteTo(io.Discard)
+	}
+}
+
+func BenchmarkReadFrom(b *testing.B) {
+	v := New(10000000)
+	for i := uint(0); i < v.Cap(); i += 7 {
+		v.SetBit(i)
+	}
+	data, _ := v.MarshalBinary()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var w BitSet
+		w.ReadFrom(bytes.NewReader(data))
+	}
+}
+
+func TestBitE(t *testing.T) {
+	v := New(65)
+	if err := v.SetBitE(64); err != nil {
+		t.Errorf("SetBitE(64) failed: %v", err)
+	}
+	if on, err := v.BitE(64); err != nil || !on {
+		t.Errorf("BitE(64) returned %v, %v, but it should be true, nil", on, err)
+	}
+	if err := v.ClearBitE(64); err != nil {
+		t.Errorf("ClearBitE(64) failed: %v", err)
+	}
+	if on, err := v.BitE(64); err != nil || on {
+		t.Errorf("BitE(64) returned %v, %v, but it should be false, nil", on, err)
+	}
+}
+
+func TestBitEOutOfRange(t *testing.T) {
+	v := New(65)
+	_, e1 := v.BitE(65)
+	e2 := v.SetBitE(1000)
+	e3 := v.ClearBitE(66)
+	var n *BitSet
+	_, e4 := n.BitE(0)
+	want := []ErrOutOfRange{{65, 65}, {1000, 65}, {66, 65}, {0, 0}}
+	for k, err := range []error{e1, e2, e3, e4} {
+		var oor ErrOutOfRange
+		if !errors.As(err, &oor) || oor != want[k] {
+			t.Errorf("Got error %v, but it should be %v", err, want[k])
+		}
+	}
+	if v.Count() != 0 {
+		t.Errorf("Out of range calls should not change the set")
+	}
+}
+
+func TestOutOfBoundsPanicValue(t *testing.T) {
+	v := New(64)
+	defer func() {
+		if r := recover(); r != (ErrOutOfRange{1000, 64}) {
+			t.Errorf("Panic was %v, but it should be ErrOutOfRange{1000, 64}", r)
+		}
+	}()
+	v.Bit(1000)
+}
+
+func TestGrow(t *testing.T) {
+	v := New(100)
+	v.SetBit(0)
+	v.SetBit(98)
+	v.SetBit(99)
+	v.Grow(50)
+	if v.Cap() != 100 {
+		t.Errorf("Grow to a smaller capacity should be a no-op, but Cap is %d", v.Cap())
+	}
+	v.Grow(1000)
+	if v.Cap() != 1000 {
+		t.Errorf("Cap should be 1000, but is %d.", v.Cap())
+	}
+	for i := uint(0); i < 1000; i++ {
+		want := i == 0 || i == 98 || i == 99
+		if v.Bit(i) != want {
+			t.Errorf("Bit %d is %v after Grow, but it should be %v", i, v.Bit(i), want)
+		}
+	}
+	v.SetBit(999)
+	if v.Count() != 4 {
+		t.Errorf("Count reported as %d, but it should be 4", v.Count())
+	}
+}
+
+func TestGrowReusesSlice(t *testing.T) {
+	v := &BitSet{capacity: 64, set: make([]uint64, 1, 4)}
+	v.SetBit(63)
+	v.set[:4][2] = 0xff // stale data in the spare room
+	p := &v.set[0]
+	v.Grow(256)
+	if &v.set[0] != p {
+		t.Errorf("Grow should reuse a backing slice with enough room")
+	}
+	if v.Count() != 1 || !v.Bit(63) {
+		t.Errorf("Grow should clear the reused words, but Count is %d", v.Count())
+	}
+}
+
+func TestShrink(t *testing.T) {
+	v := New(200)
+	v.SetBit(3)
+	v.SetBit(69)
+	v.SetBit(70)
+	v.SetBit(150)
+	w := v.Shrink(70)
+	if w.Cap() != 70 || w.Count() != 2 || !w.Bit(3) || !w.Bit(69) {
+		t.Errorf("Shrink(70) should keep bits 3 and 69 only, but has %d bits", w.Count())
+	}
+	if v.Count() != 4 {
+		t.Errorf("Shrink should not modify the receiver")
+	}
+	if u := v.Shrink(500); !u.Equ(v) {
+		t.Errorf("Shrink beyond capacity should copy the set unchanged")
+	}
+	if u := v.Shrink(0); u.Cap() != 0 || u.Count() != 0 {
+		t.Errorf("Shrink(0) should be empty")
+	}
+}
+
+func TestCompact(t *testing.T) {
+	v := New(100000)
+	v.SetBit(5)
+	v.SetBit(129)
+	v.Compact()
+	if v.Cap() != 130 || len(v.set) != 3 || cap(v.set) != 3 {
+		t.Errorf("Compact should cut capacity to 130 in 3 words, but Cap is %d in %d", v.Cap(), cap(v.set))
+	}
+	if !v.Bit(5) || !v.Bit(129) || v.Count() != 2 {
+		t.Errorf("Compact should preserve the set bits")
+	}
+	if _, err := v.BitE(130); err == nil {
+		t.Errorf("Bit 130 should be out of range after Compact")
+	}
+	e := New(1000)
+	e.Compact()
+	if e.Cap() != 0 || e.Count() != 0 {
+		t.Errorf("Compact of an empty set should leave capacity 0, but Cap is %d", e.Cap())
+	}
+}
+
+func BenchmarkCount(b *testing.B) {
+	v := New(64000000)
+	for i := range v.set {
+		v.set[i] = ^uint64(0)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		v.Count()
+	}
+}
+
+func TestSetAll(t *testing.T) {
+	for _, capacity := range []uint{0, 1, 64, 65, 130} {
+		v := New(capacity)
+		v.SetAll()
+		if v.Count() != capacity {
+			t.Errorf("SetAll on New(%d) counts %d bits, but it should be %d", capacity, v.Count(), capacity)
+		}
+		w := New(capacity)
+		for i := uint(0); i < capacity; i++ {
+			w.SetBit(i)
+		}
+		if !v.Equ(w) {
+			t.Errorf("SetAll on New(%d) should equal setting each bit", capacity)
+		}
+	}
+}
+
+func TestXorAll(t *testing.T) {
+	v := New(65)
+	v.SetBit(64)
+	v.XorAll()
+	if v.Count() != 64 || v.Bit(64) {
+		t.Errorf("XorAll on New(65) counts %d bits, but it should be 64", v.Count())
+	}
+	v.XorAll()
+	if v.Count() != 1 || !v.Bit(64) {
+		t.Errorf("XorAll twice should restore the set")
+	}
+}
+
+func TestAnyNoneAll(t *testing.T) {
+	v := New(130)
+	if v.Any() || !v.None() || v.All() {
+		t.Errorf("An empty set should have None, not Any or All")
+	}
+	v.SetBit(129)
+	if !v.Any() || v.None() || v.All() {
+		t.Errorf("A set with one bit should have Any, not None or All")
+	}
+	v.SetAll()
+	if !v.Any() || v.None() || !v.All() {
+		t.Errorf("A full set should have Any and All, not None")
+	}
+	v.ClearBit(0)
+	if v.All() {
+		t.Errorf("A set with bit 0 clear should not have All")
+	}
+	w := New(128)
+	w.SetAll()
+	if !w.All() {
+		t.Errorf("A full word-aligned set should have All")
+	}
+	var n *BitSet
+	if n.Any() || !n.None() || !n.All() {
+		t.Errorf("A nil set should have None and All, not Any")
+	}
+}
+
+func TestRangeOps(t *testing.T) {
+	r := rand.New(rand.NewSource(13))
+	ranges := [][2]uint{{0, 0}, {5, 5}, {0, 200}, {3, 61}, {3, 64}, {60, 70}, {64, 128}, {1, 199}, {127, 129}, {199, 200}}
+	for k := 0; k < 50; k++ {
+		start := uint(r.Intn(201))
+		end := start + uint(r.Intn(201-int(start)))
+		ranges = append(ranges, [2]uint{start, end})
+	}
+	ops := []struct {
+		name  string
+		apply func(v *BitSet, start, end uint)
+		f     func(old bool) bool
+	}{
+		{"SetRange", (*BitSet).SetRange, func(bool) bool { return true }},
+		{"ClearRange", (*BitSet).ClearRange, func(bool) bool { return false }},
+		{"FlipRange", (*BitSet).FlipRange, func(old bool) bool { return !old }},
+	}
+	for _, rg := range ranges {
+		for _, op := range ops {
+			v := randomSet(r, 200, 2)
+			w := v.Clone()
+			op.apply(w, rg[0], rg[1])
+			for i := uint(0); i < 200; i++ {
+				want := v.Bit(i)
+				if i >= rg[0] && i < rg[1] {
+					want = op.f(want)
+				}
+				if w.Bit(i) != want {
+					t.Errorf("%s(%d, %d): bit %d is %v, but it should be %v", op.name, rg[0], rg[1], i, w.Bit(i), want)
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestRangeOutOfBounds(t *testing.T) {
+	v := New(100)
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("SetRange beyond capacity should have caused a panic")
+		}
+	}()
+	v.SetRange(50, 101)
+}
+
+func TestCountRange(t *testing.T) {
+	r := rand.New(rand.NewSource(14))
+	v := randomSet(r, 1000, 3)
+	for k := 0; k < 500; k++ {
+		start := uint(r.Intn(1001))
+		end := start + uint(r.Intn(1001-int(start)))
+		want := uint(0)
+		for i := start; i < end; i++ {
+			if v.Bit(i) {
+				want++
+			}
+		}
+		if got := v.CountRange(start, end); got != want {
+			t.Errorf("CountRange(%d, %d) reported %d, but it should be %d", start, end, got, want)
+		}
+	}
+	if v.CountRange(0, 1000) != v.Count() {
+		t.Errorf("CountRange over the whole set should equal Count")
+	}
+}
+
+func BenchmarkCountRangeSmallWindow(b *testing.B) {
+	v := New(64000000)
+	v.SetAll()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		v.CountRange(32000010, 32000300)
+	}
+}
+
+func TestSetTo(t *testing.T) {
+	r := rand.New(rand.NewSource(15))
+	v := New(300)
+	w := New(300)
+	for k := 0; k < 5000; k++ {
+		i := uint(r.Intn(300))
+		value := r.Intn(2) == 0
+		v.SetTo(i, value)
+		if value {
+			w.SetBit(i)
+		} else {
+			w.ClearBit(i)
+		}
+	}
+	if !v.Equ(w) {
+		t.Errorf("SetTo should match SetBit and ClearBit")
+	}
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("SetTo out of range should have caused a panic")
+		}
+	}()
+	v.SetTo(300, true)
+}
+
+func TestFlipBit(t *testing.T) {
+	v := New(100)
+	if !v.FlipBit(70) || !v.Bit(70) {
+		t.Errorf("FlipBit on a clear bit should set it and return true")
+	}
+	if v.FlipBit(70) || v.Bit(70) {
+		t.Errorf("FlipBit on a set bit should clear it and return false")
+	}
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("FlipBit out of range should have caused a panic")
+		}
+	}()
+	v.FlipBit(100)
+}
+
+func TestString(t *testing.T) {
+	v := New(2000)
+	if s := v.String(); s != "{}" {
+		t.Errorf("String of an empty set is %q, but it should be \"{}\"", s)
+	}
+	v.SetBit(1)
+	v.SetBit(999)
+	v.SetBit(1000)
+	if s := v.String(); s != "{1,999,1000}" {
+		t.Errorf("String is %q, but it should be \"{1,999,1000}\"", s)
+	}
+	v.SetAll()
+	s := v.String()
+	want := "," + strconv.Itoa(maxStringIndices-1) + ",...}"
+	if !strings.HasPrefix(s, "{0,1,2,") || !strings.HasSuffix(s, want) {
+		t.Errorf("String of a dense set should be elided after %d indices", maxStringIndices)
+	}
+	var n *BitSet
+	if s := n.String(); s != "{}" {
+		t.Errorf("String of nil is %q, but it should be \"{}\"", s)
+	}
+}
+
+func TestDumpAsBits(t *testing.T) {
+	v := New(10)
+	v.SetBit(0)
+	v.SetBit(3)
+	v.SetBit(9)
+	if s := v.DumpAsBits(); s != "1001000001" {
+		t.Errorf("DumpAsBits is %q, but it should be \"1001000001\"", s)
+	}
+	w := New(130)
+	w.SetAll()
+	if s := w.DumpAsBits(); s != strings.Repeat("1", 130) {
+		t.Errorf("DumpAsBits of New(130) has %d characters, but it should have 130", len(s))
+	}
+	if s := New(0).DumpAsBits(); s != "" {
+		t.Errorf("DumpAsBits of an empty set is %q, but it should be empty", s)
+	}
+}
+
+func TestNewFromBits(t *testing.T) {
+	for _, s := range []string{"", "0", "1", "0101", strings.Repeat("10", 40) + "111"} {
+		v, err := NewFromBits(s)
+		if err != nil {
+			t.Fatalf("NewFromBits(%q) failed: %v", s, err)
+		}
+		if v.Cap() != uint(len(s)) || v.DumpAsBits() != s {
+			t.Errorf("NewFromBits(%q) round trips to %q", s, v.DumpAsBits())
+		}
+	}
+	if _, err := NewFromBits("01x1"); err == nil || !strings.Contains(err.Error(), "position 2") {
+		t.Errorf("NewFromBits(\"01x1\") should fail naming position 2, but got %v", err)
+	}
+}
+
+func TestNewFromIndices(t *testing.T) {
+	v := NewFromIndices(200, []uint{199, 3, 64, 3})
+	if v.Cap() != 200 || v.Count() != 3 || !v.Bit(3) || !v.Bit(64) || !v.Bit(199) {
+		t.Errorf("NewFromIndices should set bits 3, 64 and 199, but has %v", v)
+	}
+	got := v.Indices()
+	want := []uint{3, 64, 199}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Errorf("Indices returned %v, but it should be %v", got, want)
+	}
+	if len(New(10).Indices()) != 0 {
+		t.Errorf("Indices of an empty set should be empty")
+	}
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("NewFromIndices with an out of range index should have caused a panic")
+		}
+	}()
+	NewFromIndices(10, []uint{10})
+}
+
+func TestClone(t *testing.T) {
+	v := New(100)
+	v.SetBit(99)
+	c := v.Clone()
+	if c.Cap() != 100 || !c.Equ(v) {
+		t.Errorf("Clone of New(100) should be equal to it")
+	}
+	c.ClearBit(99)
+	if !v.Bit(99) {
+		t.Errorf("Changing a clone should not change the original")
+	}
+	var n *BitSet
+	if n.Clone() != nil {
+		t.Errorf("Clone of nil should be nil")
+	}
+}
+
+func TestIntersects(t *testing.T) {
+	a := NewFromIndices(100, []uint{3, 70})
+	b := NewFromIndices(1000, []uint{4, 71, 500})
+	if a.Intersects(b) || b.Intersects(a) {
+		t.Errorf("Disjoint sets should not intersect")
+	}
+	b.SetBit(70)
+	if !a.Intersects(b) || !b.Intersects(a) {
+		t.Errorf("Sets sharing bit 70 should intersect")
+	}
+	if a.Intersects(nil) || a.Intersects(New(0)) {
+		t.Errorf("Nothing intersects an empty set")
+	}
+	r := rand.New(rand.NewSource(22))
+	for k := 0; k < 200; k++ {
+		x := randomSet(r, uint(r.Intn(300)), 40)
+		y := randomSet(r, uint(r.Intn(300)), 40)
+		if x.Intersects(y) != x.And(y).Any() {
+			t.Errorf("Intersects should agree with And(...).Any()")
+		}
+	}
+}
+
+func BenchmarkIntersects(b *testing.B) {
+	x := New(1000000)
+	y := New(1000000)
+	x.SetBit(999999)
+	y.SetBit(999998)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		x.Intersects(y)
+	}
+}
+
+func TestIsSubset(t *testing.T) {
+	a := NewFromIndices(200, []uint{3, 70, 150})
+	b := a.Clone()
+	if !a.IsSubset(b) || !a.IsSuperset(b) {
+		t.Errorf("Equal sets should be both subset and superset")
+	}
+	b.SetBit(199)
+	if !a.IsSubset(b) || a.IsSuperset(b) || b.IsSubset(a) || !b.IsSuperset(a) {
+		t.Errorf("A strict subset should not be a superset")
+	}
+	small := NewFromIndices(100, []uint{3, 70})
+	if !small.IsSubset(a) || !a.IsSuperset(small) {
+		t.Errorf("A smaller capacity subset should be a subset")
+	}
+	if a.IsSubset(small) {
+		t.Errorf("A set with bits beyond the other's capacity cannot be a subset")
+	}
+	a.ClearBit(150)
+	if !a.IsSubset(small) {
+		t.Errorf("A larger capacity set with no bits beyond the other's capacity can be a subset")
+	}
+	if !New(0).IsSubset(a) || !a.IsSuperset(nil) || a.IsSubset(nil) {
+		t.Errorf("The empty set is a subset of everything")
+	}
+}
+
+func TestCardinalityCounts(t *testing.T) {
+	r := rand.New(rand.NewSource(24))
+	caps := []uint{0, 1, 63, 64, 65, 130, 200}
+	for _, ca := range caps {
+		for _, cb := range caps {
+			a := randomSet(r, ca, 3)
+			b := randomSet(r, cb, 3)
+			if got, want := a.IntersectionCount(b), a.And(b).Count(); got != want {
+				t.Errorf("IntersectionCount(%d, %d) is %d, but it should be %d", ca, cb, got, want)
+			}
+			if got, want := a.UnionCount(b), a.Or(b).Count(); got != want {
+				t.Errorf("UnionCount(%d, %d) is %d, but it should be %d", ca, cb, got, want)
+			}
+			if got, want := a.DifferenceCount(b), a.AndNot(b).Count(); got != want {
+				t.Errorf("DifferenceCount(%d, %d) is %d, but it should be %d", ca, cb, got, want)
+			}
+		}
+	}
+	a := NewFromIndices(10, []uint{1, 2})
+	if a.UnionCount(nil) != 2 || a.IntersectionCount(nil) != 0 || a.DifferenceCount(nil) != 2 {
+		t.Errorf("A nil operand should act as an empty set")
+	}
+}
+
+func TestShifts(t *testing.T) {
+	r := rand.New(rand.NewSource(25))
+	for _, capacity := range []uint{1, 63, 64, 65, 130, 200, 256} {
+		for _, n := range []uint{0, 1, 7, 63, 64, 65, 128, 129, 199, 200, 300} {
+			v := randomSet(r, capacity, 2)
+			left := v.Clone()
+			left.ShiftLeft(n)
+			right := v.Clone()
+			right.ShiftRight(n)
+			for i := uint(0); i < capacity; i++ {
+				wantLeft := i >= n && v.Bit(i-n)
+				wantRight := i+n < capacity && v.Bit(i+n)
+				if left.Bit(i) != wantLeft {
+					t.Errorf("ShiftLeft(%d) on capacity %d: bit %d is %v, but it should be %v", n, capacity, i, left.Bit(i), wantLeft)
+					break
+				}
+				if right.Bit(i) != wantRight {
+					t.Errorf("ShiftRight(%d) on capacity %d: bit %d is %v, but it should be %v", n, capacity, i, right.Bit(i), wantRight)
+					break
+				}
+			}
+			if left.Count() > capacity || left.Count() != left.CountRange(0, capacity) {
+				t.Errorf("ShiftLeft(%d) on capacity %d left bits beyond the capacity", n, capacity)
+			}
+		}
+	}
+}
+
+func TestFlip(t *testing.T) {
+	r := rand.New(rand.NewSource(26))
+	v := randomSet(r, 130, 3)
+	before := v.Count()
+	w := v.Clone()
+	w.Flip()
+	if w.Count() != 130-before {
+		t.Errorf("Flip counts %d bits, but it should be %d", w.Count(), 130-before)
+	}
+	for i := uint(0); i < 130; i++ {
+		if w.Bit(i) == v.Bit(i) {
+			t.Errorf("Flip left bit %d unchanged", i)
+			break
+		}
+	}
+	w.Flip()
+	if !w.Equ(v) {
+		t.Errorf("Flip twice should restore the set")
+	}
+}
+
+func TestFlipAllPadding(t *testing.T) {
+	r := rand.New(rand.NewSource(89))
+	for _, capacity := range []uint{1, 63, 64, 65, 100, 130} {
+		v := randomSet(r, capacity, 3)
+		w := v.Clone()
+		w.FlipAll()
+		if w.Count() != capacity-v.Count() || w.set[len(w.set)-1]&^w.lastWordMask() != 0 {
+			t.Errorf("FlipAll at capacity %d should leave the padding clear", capacity)
+		}
+		for _, start := range []uint{0, capacity / 2, capacity - 1, capacity} {
+			w := v.Clone()
+			w.FlipRange(start, capacity)
+			if w.Count() > capacity || w.set[len(w.set)-1]&^w.lastWordMask() != 0 {
+				t.Errorf("FlipRange(%d, %d) should leave the padding clear", start, capacity)
+			}
+			if w.CountRange(start, capacity) != capacity-start-v.CountRange(start, capacity) || w.CountRange(0, start) != v.CountRange(0, start) {
+				t.Errorf("FlipRange(%d, %d) should flip exactly the bits of the range", start, capacity)
+			}
+		}
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	r := rand.New(rand.NewSource(30))
+	for _, capacity := range []uint{0, 1, 64, 100, 1000} {
+		v := randomSet(r, capacity, 3)
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("MarshalJSON failed: %v", err)
+		}
+		var w *BitSet
+		if err := json.Unmarshal(data, &w); err != nil {
+			t.Fatalf("UnmarshalJSON of %s failed: %v", data, err)
+		}
+		if !w.Equ(v) {
+			t.Errorf("JSON round trip of capacity %d did not preserve the set", capacity)
+		}
+	}
+	v := NewFromIndices(100, []uint{0, 99})
+	data, _ := json.Marshal(struct{ Mask *BitSet }{v})
+	if string(data) != `{"Mask":{"cap":100,"bits":"AQAAAAAAAAAAAAAACAAAAA=="}}` {
+		t.Errorf("MarshalJSON produced %s", data)
+	}
+}
+
+func TestUnmarshalJSONBad(t *testing.T) {
+	for _, bad := range []string{
+		`{"cap":100,"bits":"!!!"}`,
+		`{"cap":100,"bits":"AQAAAAAAAAA="}`,
+		`{"cap":64,"bits":"AQAAAAAAAAAAAAAACAAAAA=="}`,
+		`{"cap":1,"bits":"AwAAAAAAAAA="}`,
+		`{"cap":"x"}`,
+	} {
+		var w BitSet
+		if err := json.Unmarshal([]byte(bad), &w); err == nil {
+			t.Errorf("UnmarshalJSON of %s should have failed", bad)
+		}
+	}
+}
+
+func TestGob(t *testing.T) {
+	gob.Register(&BitSet{})
+	r := rand.New(rand.NewSource(31))
+	for _, capacity := range []uint{0, 1, 100, 1000} {
+		v := randomSet(r, capacity, 3)
+		var buf bytes.Buffer
+		in := struct {
+			Direct *BitSet
+			Any    interface{}
+		}{v, v}
+		if err := gob.NewEncoder(&buf).Encode(in); err != nil {
+			t.Fatalf("gob encoding failed: %v", err)
+		}
+		var out struct {
+			Direct *BitSet
+			Any    interface{}
+		}
+		if err := gob.NewDecoder(&buf).Decode(&out); err != nil {
+			t.Fatalf("gob decoding failed: %v", err)
+		}
+		w, ok := out.Any.(*BitSet)
+		if !ok || !out.Direct.Equ(v) || !w.Equ(v) {
+			t.Errorf("gob round trip of capacity %d did not preserve the set", capacity)
+		}
+	}
+}
+
+func TestRankSelect(t *testing.T) {
+	r := rand.New(rand.NewSource(32))
+	v := randomSet(r, 1000, 4)
+	rank := uint(0)
+	for i := uint(0); i <= 1000; i++ {
+		if got := v.Rank(i); got != rank {
+			t.Errorf("Rank(%d) is %d, but it should be %d", i, got, rank)
+			break
+		}
+		if i < 1000 && v.Bit(i) {
+			if j, ok := v.Select(rank); !ok || j != i {
+				t.Errorf("Select(%d) returned %d, %v, but it should be %d, true", rank, j, ok, i)
+			}
+			rank++
+		}
+	}
+	if v.Rank(5000) != v.Count() {
+		t.Errorf("Rank beyond capacity should count every set bit")
+	}
+	if _, ok := v.Select(v.Count()); ok {
+		t.Errorf("Select(Count()) should find nothing")
+	}
+	if _, ok := New(0).Select(0); ok {
+		t.Errorf("Select on an empty set should find nothing")
+	}
+}
+
+func BenchmarkSelect(b *testing.B) {
+	r := rand.New(rand.NewSource(32))
+	v := randomSet(r, 1000000, 2)
+	n := v.Count()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		v.Select(uint(i) % n)
+	}
+}
+
+func TestSub(t *testing.T) {
+	r := rand.New(rand.NewSource(33))
+	for _, capacity := range []uint{0, 1, 64, 100, 129, 192, 200, 256} {
+		v := randomSet(r, capacity, 2)
+		ranges := [][2]uint{{0, capacity}, {capacity, capacity}}
+		// lengths that are exact multiples of 64 from unaligned starts,
+		// ending exactly on the capacity
+		for l := uint(64); l < capacity; l += 64 {
+			ranges = append(ranges, [2]uint{capacity - l, capacity}, [2]uint{1, 1 + l})
+		}
+		for k := 0; k < 20; k++ {
+			start := uint(r.Intn(int(capacity) + 1))
+			end := start + uint(r.Intn(int(capacity-start)+1))
+			ranges = append(ranges, [2]uint{start, end})
+		}
+		for _, rg := range ranges {
+			s := v.Sub(rg[0], rg[1])
+			if s.Cap() != rg[1]-rg[0] {
+				t.Errorf("Sub(%d, %d) has capacity %d", rg[0], rg[1], s.Cap())
+				continue
+			}
+			for i := uint(0); i < s.Cap(); i++ {
+				if s.Bit(i) != v.Bit(rg[0]+i) {
+					t.Errorf("Sub(%d, %d) of capacity %d: bit %d is wrong", rg[0], rg[1], capacity, i)
+					break
+				}
+			}
+			if s.Count() != v.CountRange(rg[0], rg[1]) {
+				t.Errorf("Sub(%d, %d) of capacity %d counts %d bits, but it should be %d", rg[0], rg[1], capacity, s.Count(), v.CountRange(rg[0], rg[1]))
+			}
+		}
+	}
+}
+
+func TestSubInto(t *testing.T) {
+	r := rand.New(rand.NewSource(34))
+	v := randomSet(r, 300, 2)
+	dst := New(200)
+	for k := 0; k < 100; k++ {
+		dst.SetAll()
+		start := uint(r.Intn(301))
+		end := start + uint(r.Intn(301-int(start)))
+		err := v.SubInto(dst, start, end)
+		if end-start > 200 {
+			if err == nil {
+				t.Errorf("SubInto(%d, %d) into capacity 200 should have failed", start, end)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("SubInto(%d, %d) failed: %v", start, end, err)
+		}
+		want := v.Sub(start, end)
+		for i := uint(0); i < 200; i++ {
+			if dst.Bit(i) != bitOrZero(want, i) {
+				t.Errorf("SubInto(%d, %d): bit %d is %v", start, end, i, dst.Bit(i))
+				break
+			}
+		}
+	}
+	if err := v.SubInto(nil, 0, 1); err == nil {
+		t.Errorf("SubInto a nil destination should have failed")
+	}
+	if err := v.SubInto(nil, 5, 5); err != nil {
+		t.Errorf("SubInto of an empty range into nil failed: %v", err)
+	}
+}
+
+func BenchmarkSubInto(b *testing.B) {
+	v := New(100000)
+	v.SetAll()
+	dst := New(1000)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		v.SubInto(dst, uint(i)%1000, uint(i)%1000+1000)
+	}
+}
+
+func TestEqualBits(t *testing.T) {
+	a := NewFromIndices(100, []uint{3, 99})
+	b := NewFromIndices(128, []uint{3, 99})
+	if !a.EqualBits(b) || !b.EqualBits(a) {
+		t.Errorf("Sets with the same bits should be EqualBits regardless of capacity")
+	}
+	if a.Equ(b) {
+		t.Errorf("Equ should still compare capacities")
+	}
+	b.Grow(1000)
+	b.SetBit(900)
+	if a.EqualBits(b) || b.EqualBits(a) {
+		t.Errorf("A bit beyond the smaller capacity should make the sets differ")
+	}
+	b.ClearBit(99)
+	b.ClearBit(900)
+	if a.EqualBits(b) {
+		t.Errorf("Sets differing in a shared word should not be EqualBits")
+	}
+	if !New(500).EqualBits(nil) || a.EqualBits(nil) {
+		t.Errorf("A nil set should be EqualBits to any empty set")
+	}
+}
+
+func TestHash(t *testing.T) {
+	r := rand.New(rand.NewSource(36))
+	seen := make(map[uint64]*BitSet)
+	for k := 0; k < 10000; k++ {
+		v := randomSet(r, uint(r.Intn(300)), 2+r.Intn(20))
+		h := v.Hash()
+		if v.Clone().Hash() != h {
+			t.Fatalf("A clone should hash like the original")
+		}
+		if w, ok := seen[h]; ok && !w.Equ(v) {
+			t.Errorf("Sets %v and %v share hash %x", w, v, h)
+		}
+		seen[h] = v
+	}
+	if New(100).Hash() == New(128).Hash() {
+		t.Errorf("Empty sets of different capacities should hash differently")
+	}
+	var n *BitSet
+	if n.Hash() != New(0).Hash() {
+		t.Errorf("A nil set should hash like an empty set of capacity 0")
+	}
+}
+
+func TestPopCounts(t *testing.T) {
+	r := rand.New(rand.NewSource(37))
+	caps := []uint{0, 1, 64, 65, 200}
+	for _, ca := range caps {
+		for _, cb := range caps {
+			a := randomSet(r, ca, 3)
+			b := randomSet(r, cb, 3)
+			if PopCountAnd(a, b) != a.And(b).Count() || PopCountOr(a, b) != a.Or(b).Count() ||
+				PopCountXor(a, b) != a.Xor(b).Count() || PopCountAndNot(a, b) != a.AndNot(b).Count() {
+				t.Errorf("PopCount functions disagree with the set operations for capacities %d and %d", ca, cb)
+			}
+		}
+	}
+	if PopCountXor(nil, NewFromIndices(10, []uint{1})) != 1 {
+		t.Errorf("A nil operand should act as an empty set")
+	}
+}
+
+func TestToBytes(t *testing.T) {
+	v := NewFromIndices(13, []uint{0, 9, 12})
+	data := v.ToBytes()
+	if len(data) != 2 || data[0] != 0x01 || data[1] != 0x12 {
+		t.Errorf("ToBytes returned %x, but it should be 0112", data)
+	}
+	r := rand.New(rand.NewSource(38))
+	for _, capacity := range []uint{0, 1, 7, 8, 9, 63, 64, 65, 130} {
+		v := randomSet(r, capacity, 2)
+		w, err := FromBytes(capacity, v.ToBytes())
+		if err != nil {
+			t.Fatalf("FromBytes failed: %v", err)
+		}
+		if !w.Equ(v) || len(v.ToBytes()) != int(capacity+7)/8 {
+			t.Errorf("Bytes round trip of capacity %d did not preserve the set", capacity)
+		}
+	}
+}
+
+func TestFromBytes(t *testing.T) {
+	w, err := FromBytes(10, []byte{0xff, 0xff, 0xff})
+	if err != nil {
+		t.Fatalf("FromBytes failed: %v", err)
+	}
+	if w.Count() != 10 || w.ToBytes()[1] != 0x03 {
+		t.Errorf("FromBytes should ignore the bits beyond capacity, but counts %d", w.Count())
+	}
+	if _, err := FromBytes(17, []byte{1, 2}); err == nil {
+		t.Errorf("FromBytes with too few bytes should have failed")
+	}
+}
+
+func TestUint32s(t *testing.T) {
+	v := NewWithBits(70, 0, 33, 64, 69)
+	words := v.ToUint32s()
+	if len(words) != 3 || words[0] != 1 || words[1] != 2 || words[2] != 0x21 {
+		t.Errorf("ToUint32s returned %x, but it should be [1 2 21]", words)
+	}
+	r := rand.New(rand.NewSource(77))
+	for _, capacity := range []uint{0, 1, 31, 32, 33, 63, 64, 65, 96, 97, 130} {
+		v := randomSet(r, capacity, 2)
+		w, err := FromUint32s(capacity, v.ToUint32s())
+		if err != nil {
+			t.Fatalf("FromUint32s failed: %v", err)
+		}
+		if !w.Equ(v) || len(v.ToUint32s()) != int(capacity+31)/32 {
+			t.Errorf("32-bit word round trip of capacity %d did not preserve the set", capacity)
+		}
+	}
+	w, err := FromUint32s(40, []uint32{^uint32(0), ^uint32(0), ^uint32(0)})
+	if err != nil || w.Count() != 40 {
+		t.Errorf("FromUint32s should ignore the bits beyond capacity: %v", err)
+	}
+	if _, err := FromUint32s(65, []uint32{1, 2}); err == nil {
+		t.Errorf("FromUint32s with too few words should have failed")
+	}
+}
+
+func TestBoolSlice(t *testing.T) {
+	r := rand.New(rand.NewSource(39))
+	for _, n := range []int{0, 1, 64, 65, 300} {
+		in := make([]bool, n)
+		for i := range in {
+			in[i] = r.Intn(2) == 0
+		}
+		v := FromBoolSlice(in)
+		out := v.ToBoolSlice()
+		if v.Cap() != uint(n) || len(out) != n {
+			t.Errorf("FromBoolSlice of %d bools has capacity %d", n, v.Cap())
+			continue
+		}
+		for i := range in {
+			if v.Bit(uint(i)) != in[i] || out[i] != in[i] {
+				t.Errorf("Bool slice round trip of length %d differs at %d", n, i)
+				break
+			}
+		}
+	}
+}
+
+func TestReset(t *testing.T) {
+	v := New(1000)
+	v.SetAll()
+	p := &v.set[0]
+	v.Reset(100)
+	if v.Cap() != 100 || v.Count() != 0 || &v.set[0] != p {
+		t.Errorf("Reset to a smaller capacity should clear the set in place")
+	}
+	v.SetBit(99)
+	v.Reset(900)
+	if v.Cap() != 900 || v.Count() != 0 || &v.set[0] != p {
+		t.Errorf("Reset within the backing array should not reallocate or leave stale bits")
+	}
+	v.Reset(5000)
+	if v.Cap() != 5000 || v.Count() != 0 || len(v.set) != 79 {
+		t.Errorf("Reset beyond the backing array should allocate a clear set")
+	}
+	v.Reset(0)
+	if v.Cap() != 0 || v.Any() {
+		t.Errorf("Reset(0) should leave an empty set of capacity 0")
+	}
+}
+
+func BenchmarkReset(b *testing.B) {
+	v := New(100000)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		v.Reset(uint(50000 + i%50000))
+	}
+}
+
+func BenchmarkResetNew(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		New(uint(50000 + i%50000))
+	}
+}
+
+func TestSymmetricDifference(t *testing.T) {
+	a := NewFromIndices(100, []uint{1, 2, 3})
+	b := NewFromIndices(200, []uint{3, 4, 150})
+	d := a.SymmetricDifference(b)
+	if d.Cap() != 200 || !d.Equ(NewFromIndices(200, []uint{1, 2, 4, 150})) {
+		t.Errorf("SymmetricDifference is %v, but it should be {1,2,4,150}", d)
+	}
+	if a.SymmetricDifferenceCount(b) != 4 || b.SymmetricDifferenceCount(a) != 4 {
+		t.Errorf("SymmetricDifferenceCount should be 4")
+	}
+}
+
+func TestNilReadOnly(t *testing.T) {
+	var v *BitSet
+	c := NewFromIndices(100, []uint{5})
+	if v.Cap() != 0 || v.Count() != 0 || v.Any() || !v.None() || !v.All() {
+		t.Errorf("A nil set should read as an empty set of capacity 0")
+	}
+	if _, ok := v.NextSet(0); ok {
+		t.Errorf("NextSet on nil should find nothing")
+	}
+	if _, ok := v.NextClear(0); ok {
+		t.Errorf("NextClear on nil should find nothing")
+	}
+	if _, ok := v.Select(0); ok || v.Rank(10) != 0 || v.CountRange(0, 0) != 0 {
+		t.Errorf("Rank, Select and CountRange on nil should see no bits")
+	}
+	if !v.Equ(New(0)) || !v.EqualBits(New(10)) || v.Intersects(c) || !v.IsSubset(c) || v.IsSuperset(c) {
+		t.Errorf("Comparisons with nil should treat it as empty")
+	}
+	if v.And(c).Cap() != 0 || !v.Or(c).Equ(c) || !v.Xor(c).Equ(c) || v.AndNot(c).Cap() != 0 {
+		t.Errorf("Set operations on nil should treat it as empty")
+	}
+	if v.UnionCount(c) != 1 || v.IntersectionCount(c) != 0 || v.DifferenceCount(c) != 0 {
+		t.Errorf("Counts on nil should treat it as empty")
+	}
+	if v.Shrink(10).Cap() != 0 || v.Sub(0, 0).Cap() != 0 || v.Clone() != nil {
+		t.Errorf("Copies of nil should be empty")
+	}
+	if v.String() != "{}" || v.DumpAsBits() != "" || len(v.Indices()) != 0 || len(v.ToBytes()) != 0 || len(v.ToBoolSlice()) != 0 {
+		t.Errorf("Renderings of nil should be empty")
+	}
+	data, err := v.MarshalBinary()
+	if err != nil || len(data) != 8 {
+		t.Errorf("MarshalBinary of nil should encode an empty set")
+	}
+	var buf bytes.Buffer
+	if n, err := v.WriteTo(&buf); err != nil || n != 8 {
+		t.Errorf("WriteTo of nil should write an empty set")
+	}
+	if data, err := json.Marshal(v); err != nil || string(data) != "null" {
+		t.Errorf("MarshalJSON of nil should be null, got %s", data)
+	}
+	if data, err := v.MarshalJSON(); err != nil || string(data) != `{"cap":0,"bits":""}` {
+		t.Errorf("MarshalJSON of nil should encode an empty set, got %s", data)
+	}
+	if _, err := v.BitE(0); err == nil {
+		t.Errorf("BitE on nil should report out of range")
+	}
+}
+
+func TestNilMutatorsPanic(t *testing.T) {
+	c := New(100)
+	mutators := map[string]func(v *BitSet){
+		"SetBit":                           func(v *BitSet) { v.SetBit(0) },
+		"ClearBit":                         func(v *BitSet) { v.ClearBit(0) },
+		"SetBitE":                          func(v *BitSet) { v.SetBitE(0) },
+		"ClearBitE":                        func(v *BitSet) { v.ClearBitE(0) },
+		"FlipBit":                          func(v *BitSet) { v.FlipBit(0) },
+		"SetTo":                            func(v *BitSet) { v.SetTo(0, true) },
+		"TestAndSet":                       func(v *BitSet) { v.TestAndSet(0) },
+		"TestAndClear":                     func(v *BitSet) { v.TestAndClear(0) },
+		"SetMany":                          func(v *BitSet) { v.SetMany() },
+		"ClearMany":                        func(v *BitSet) { v.ClearMany() },
+		"SetAll":                           func(v *BitSet) { v.SetAll() },
+		"FillLow":                          func(v *BitSet) { v.FillLow(1) },
+		"XorAll":                           func(v *BitSet) { v.XorAll() },
+		"AndScalar":                        func(v *BitSet) { v.AndScalar(0) },
+		"OrScalar":                         func(v *BitSet) { v.OrScalar(0) },
+		"XorScalar":                        func(v *BitSet) { v.XorScalar(0) },
+		"Flip":                             func(v *BitSet) { v.Flip() },
+		"FlipAll":                          func(v *BitSet) { v.FlipAll() },
+		"Grow":                             func(v *BitSet) { v.Grow(10) },
+		"GrowAndSet":                       func(v *BitSet) { v.GrowAndSet(10) },
+		"Reset":                            func(v *BitSet) { v.Reset(10) },
+		"Compact":                          func(v *BitSet) { v.Compact() },
+		"TrimToFit":                        func(v *BitSet) { v.TrimToFit() },
+		"SetIf":                            func(v *BitSet) { v.SetIf(func(uint) bool { return true }) },
+		"ClearIf":                          func(v *BitSet) { v.ClearIf(func(uint) bool { return true }) },
+		"AndWith":                          func(v *BitSet) { v.AndWith(c) },
+		"OrWith":                           func(v *BitSet) { v.OrWith(c) },
+		"Merge":                            func(v *BitSet) { v.Merge(c) },
+		"XorWith":                          func(v *BitSet) { v.XorWith(c) },
+		"AndNotWith":                       func(v *BitSet) { v.AndNotWith(c) },
+		"SubtractCounting":                 func(v *BitSet) { v.SubtractCounting(c) },
+		"SetRange":                         func(v *BitSet) { v.SetRange(0, 0) },
+		"ClearRange":                       func(v *BitSet) { v.ClearRange(0, 0) },
+		"FlipRange":                        func(v *BitSet) { v.FlipRange(0, 0) },
+		"ShiftLeft":                        func(v *BitSet) { v.ShiftLeft(1) },
+		"ShiftRight":                       func(v *BitSet) { v.ShiftRight(1) },
+		"Reverse":                          func(v *BitSet) { v.Reverse() },
+		"SetWord":                          func(v *BitSet) { v.SetWord(0, 1) },
+		"PutBits":                          func(v *BitSet) { v.PutBits(0, 0, 0) },
+		"RotateLeft":                       func(v *BitSet) { v.RotateLeft(1) },
+		"RotateRight":                      func(v *BitSet) { v.RotateRight(1) },
+		"UnmarshalBinary":                  func(v *BitSet) { v.UnmarshalBinary(make([]byte, 8)) },
+		"UnmarshalJSON":                    func(v *BitSet) { v.UnmarshalJSON([]byte(`{"cap":0,"bits":""}`)) },
+		"UnmarshalText":                    func(v *BitSet) { v.UnmarshalText([]byte("cap=0;")) },
+		"UnmarshalTextBounded":             func(v *BitSet) { v.UnmarshalTextBounded([]byte("cap=0;"), 0) },
+		"UnmarshalBinaryCompressed":        func(v *BitSet) { v.UnmarshalBinaryCompressed([]byte{formatZeroRuns, 0}) },
+		"UnmarshalBinaryCompressedBounded": func(v *BitSet) { v.UnmarshalBinaryCompressedBounded([]byte{formatZeroRuns, 0}, 0) },
+		"Copy (dst)":                       func(v *BitSet) { New(10).Copy(v) },
+		"CopyBits (dst)":                   func(v *BitSet) { CopyBits(v, 0, c, 0, 0) },
+		"GobDecode":                        func(v *BitSet) { v.GobDecode(make([]byte, 8)) },
+		"ReadFrom":                         func(v *BitSet) { v.ReadFrom(bytes.NewReader(make([]byte, 8))) },
+		"OrIndicesFrom":                    func(v *BitSet) { v.OrIndicesFrom(bytes.NewReader(nil)) },
+		"SetFromLines":                     func(v *BitSet) { v.SetFromLines(strings.NewReader("")) },
+		"SetBitAtomic":                     func(v *BitSet) { v.SetBitAtomic(0) },
+		"ClearBitAtomic":                   func(v *BitSet) { v.ClearBitAtomic(0) },
+	}
+	for name, f := range mutators {
+		func() {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Errorf("%s on a nil set should have caused a panic", name)
+				}
+			}()
+			f(nil)
+		}()
+	}
+}
+
+func TestWordCounts(t *testing.T) {
+	v := New(130)
+	v.SetRange(0, 64)
+	v.SetBit(100)
+	v.SetBit(129)
+	got := v.WordCounts()
+	if len(got) != 3 || got[0] != 64 || got[1] != 1 || got[2] != 1 {
+		t.Errorf("WordCounts returned %v, but it should be [64 1 1]", got)
+	}
+	if len(New(0).WordCounts()) != 0 {
+		t.Errorf("WordCounts of an empty set should be empty")
+	}
+}
+
+func TestFirstLastSet(t *testing.T) {
+	v := New(130)
+	if _, ok := v.FirstSet(); ok {
+		t.Errorf("FirstSet on an empty set should find nothing")
+	}
+	if _, ok := v.LastSet(); ok {
+		t.Errorf("LastSet on an empty set should find nothing")
+	}
+	v.SetBit(70)
+	v.SetBit(100)
+	if i, ok := v.FirstSet(); !ok || i != 70 {
+		t.Errorf("FirstSet returned %d, %v, but it should be 70, true", i, ok)
+	}
+	if i, ok := v.LastSet(); !ok || i != 100 {
+		t.Errorf("LastSet returned %d, %v, but it should be 100, true", i, ok)
+	}
+	v.SetAll()
+	if i, ok := v.LastSet(); !ok || i != 129 {
+		t.Errorf("LastSet of a full set returned %d, %v, but it should be 129, true", i, ok)
+	}
+	var n *BitSet
+	if _, ok := n.LastSet(); ok {
+		t.Errorf("LastSet on nil should find nothing")
+	}
+}
+
+func TestTestAndSet(t *testing.T) {
+	v := New(100)
+	if v.TestAndSet(70) || !v.Bit(70) {
+		t.Errorf("TestAndSet on a clear bit should return false and set it")
+	}
+	if !v.TestAndSet(70) || !v.Bit(70) {
+		t.Errorf("TestAndSet on a set bit should return true and keep it set")
+	}
+	if !v.TestAndClear(70) || v.Bit(70) {
+		t.Errorf("TestAndClear on a set bit should return true and clear it")
+	}
+	if v.TestAndClear(70) || v.Bit(70) {
+		t.Errorf("TestAndClear on a clear bit should return false")
+	}
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("TestAndSet out of range should have caused a panic")
+		}
+	}()
+	v.TestAndSet(100)
+}
+
+func TestSetMany(t *testing.T) {
+	v := New(200)
+	v.SetMany(3, 64, 199, 3)
+	if !v.Equ(NewFromIndices(200, []uint{3, 64, 199})) {
+		t.Errorf("SetMany should set bits 3, 64 and 199, but has %v", v)
+	}
+	v.ClearMany(64, 199, 5)
+	if !v.Equ(NewFromIndices(200, []uint{3})) {
+		t.Errorf("ClearMany should leave only bit 3, but has %v", v)
+	}
+	v.SetMany()
+	v.ClearMany()
+	if v.Count() != 1 {
+		t.Errorf("SetMany and ClearMany with no indices should do nothing")
+	}
+}
+
+func TestSetManyOutOfRange(t *testing.T) {
+	v := New(100)
+	for _, f := range []func(){
+		func() { v.SetMany(1, 2, 100) },
+		func() { v.ClearMany(3, 1000) },
+	} {
+		v.SetBit(3)
+		func() {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Error("An out of range index should have caused a panic")
+				}
+			}()
+			f()
+		}()
+		if !v.Equ(NewFromIndices(100, []uint{3})) {
+			t.Errorf("A panicking bulk call should not change the set, but it has %v", v)
+		}
+	}
+}
+
+func TestRunLength(t *testing.T) {
+	v := NewFromIndices(20, []uint{3, 4, 5, 10})
+	runs := v.RunLengthEncode()
+	if len(runs) != 4 || runs[0] != 3 || runs[1] != 3 || runs[2] != 4 || runs[3] != 1 {
+		t.Errorf("RunLengthEncode returned %v, but it should be [3 3 4 1]", runs)
+	}
+	r := rand.New(rand.NewSource(48))
+	for k := 0; k < 100; k++ {
+		capacity := uint(r.Intn(2000))
+		v := New(capacity)
+		for j := 0; j < 5 && capacity > 0; j++ {
+			start := uint(r.Intn(int(capacity)))
+			v.SetRange(start, start+uint(r.Intn(int(capacity-start))))
+		}
+		w, err := RunLengthDecode(capacity, v.RunLengthEncode())
+		if err != nil {
+			t.Fatalf("RunLengthDecode failed: %v", err)
+		}
+		if !w.Equ(v) {
+			t.Errorf("Run length round trip of %v gave %v", v, w)
+		}
+	}
+	full := New(130)
+	full.SetAll()
+	if w, err := RunLengthDecode(130, full.RunLengthEncode()); err != nil || !w.Equ(full) {
+		t.Errorf("Run length round trip of a full set failed")
+	}
+}
+
+func TestRunLengthDecodeBad(t *testing.T) {
+	if _, err := RunLengthDecode(10, []uint{5, 6}); err == nil {
+		t.Errorf("Runs summing past the capacity should fail")
+	}
+	if _, err := RunLengthDecode(10, []uint{5, 5, 1}); err == nil {
+		t.Errorf("Runs summing past the capacity should fail")
+	}
+	if _, err := RunLengthDecode(10, []uint{5, 5, 0}); err != nil {
+		t.Errorf("Runs summing to the capacity should succeed: %v", err)
+	}
+}
+
+func TestComplement(t *testing.T) {
+	r := rand.New(rand.NewSource(50))
+	for _, capacity := range []uint{0, 1, 64, 65, 130} {
+		v := randomSet(r, capacity, 3)
+		orig := v.Clone()
+		c := v.Complement()
+		if !v.Equ(orig) {
+			t.Errorf("Complement should not modify the receiver")
+		}
+		if c.Cap() != capacity || c.Count() != capacity-v.Count() || c.Intersects(v) {
+			t.Errorf("Complement of capacity %d counts %d bits, but it should be %d", capacity, c.Count(), capacity-v.Count())
+		}
+	}
+	var n *BitSet
+	if c := n.Complement(); c == nil || c.Cap() != 0 {
+		t.Errorf("Complement of nil should be an empty set")
+	}
+}
+
+func TestCopy(t *testing.T) {
+	b := New(70)
+	b.SetAll()
+	c := New(200)
+	c.SetAll()
+	if n := b.Copy(c); n != 70 {
+		t.Errorf("Copy reported %d bits, but it should be 70", n)
+	}
+	if c.Count() != 70 || c.CountRange(0, 70) != 70 || c.CountRange(70, 128) != 0 {
+		t.Errorf("Copy into New(200) should leave exactly bits 0-69 set, but counts %d", c.Count())
+	}
+	d := New(10)
+	if n := b.Copy(d); n != 10 || d.Count() != 10 || !d.All() {
+		t.Errorf("Copy into New(10) should truncate to 10 bits")
+	}
+	if d.set[0] != 1<<10-1 {
+		t.Errorf("Copy should not leave bits beyond the destination's capacity")
+	}
+	var n *BitSet
+	if n.Copy(c) != 0 || c.Any() {
+		t.Errorf("Copy from nil should clear the destination")
+	}
+}
+
+func TestDifference(t *testing.T) {
+	small := NewFromIndices(70, []uint{1, 2, 65})
+	large := NewFromIndices(300, []uint{2, 65, 66, 250})
+	if d := small.Difference(large); d.Cap() != 70 || !d.Equ(NewFromIndices(70, []uint{1})) {
+		t.Errorf("Difference of the smaller set is %v, but it should be {1}", d)
+	}
+	if d := large.Difference(small); d.Cap() != 300 || !d.Equ(NewFromIndices(300, []uint{66, 250})) {
+		t.Errorf("Difference of the larger set is %v, but it should be {66,250}", d)
+	}
+	v := small.Clone()
+	v.DifferenceWith(large)
+	if !v.Equ(small.Difference(large)) {
+		t.Errorf("DifferenceWith should match Difference")
+	}
+	w := large.Clone()
+	w.DifferenceWith(small)
+	if !w.Equ(large.Difference(small)) {
+		t.Errorf("DifferenceWith should match Difference")
+	}
+}
+
+func TestReverse(t *testing.T) {
+	r := rand.New(rand.NewSource(53))
+	for _, capacity := range []uint{0, 1, 3, 7, 63, 64, 65, 127, 128, 129, 301} {
+		v := randomSet(r, capacity, 2)
+		w := v.Clone()
+		w.Reverse()
+		for i := uint(0); i < capacity; i++ {
+			if w.Bit(capacity-1-i) != v.Bit(i) {
+				t.Errorf("Reverse of capacity %d moved bit %d wrongly", capacity, i)
+				break
+			}
+		}
+		if w.Count() != v.Count() {
+			t.Errorf("Reverse of capacity %d changed the count", capacity)
+		}
+		w.Reverse()
+		if !w.Equ(v) {
+			t.Errorf("Reverse twice of capacity %d should restore the set", capacity)
+		}
+	}
+	v := NewFromIndices(5, []uint{0, 1})
+	v.Reverse()
+	if v.DumpAsBits() != "00011" {
+		t.Errorf("Reverse of 11000 is %s, but it should be 00011", v.DumpAsBits())
+	}
+}
+
+func TestRotate(t *testing.T) {
+	r := rand.New(rand.NewSource(54))
+	for _, capacity := range []uint{1, 63, 64, 100, 130} {
+		for _, n := range []uint{0, 1, 37, 63, 64, 99, 100, 237} {
+			v := randomSet(r, capacity, 2)
+			left := v.Clone()
+			left.RotateLeft(n)
+			right := v.Clone()
+			right.RotateRight(n)
+			for i := uint(0); i < capacity; i++ {
+				if left.Bit((i+n)%capacity) != v.Bit(i) {
+					t.Errorf("RotateLeft(%d) on capacity %d moved bit %d wrongly", n, capacity, i)
+					break
+				}
+				if right.Bit(i) != v.Bit((i+n)%capacity) {
+					t.Errorf("RotateRight(%d) on capacity %d moved bit %d wrongly", n, capacity, i)
+					break
+				}
+			}
+			if left.Count() != v.Count() || right.Count() != v.Count() {
+				t.Errorf("Rotating by %d on capacity %d changed the count", n, capacity)
+			}
+		}
+	}
+	v := NewFromIndices(100, []uint{70, 99})
+	v.RotateLeft(37)
+	if !v.Equ(NewFromIndices(100, []uint{7, 36})) {
+		t.Errorf("RotateLeft(37) on capacity 100 gave %v, but it should be {7,36}", v)
+	}
+	e := New(0)
+	e.RotateLeft(5)
+	e.RotateRight(5)
+}
+
+func TestGrowAndSet(t *testing.T) {
+	v := New(0)
+	for i := uint(0); i < 10000; i += 3 {
+		v.GrowAndSet(i)
+		if v.Cap() != i+1 || !v.Bit(i) {
+			t.Fatalf("GrowAndSet(%d) left capacity %d", i, v.Cap())
+		}
+	}
+	if v.Count() != 3334 || cap(v.set) != 256 {
+		t.Errorf("GrowAndSet should keep every bit and round storage to a power of two, but has %d bits in %d words", v.Count(), cap(v.set))
+	}
+	v.GrowAndSet(5)
+	if v.Cap() != 10000 || !v.Bit(5) {
+		t.Errorf("GrowAndSet below the capacity should just set the bit")
+	}
+}
+
+func BenchmarkGrowAndSet(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		v := New(0)
+		for j := uint(0); j < 100000; j += 64 {
+			v.GrowAndSet(j)
+		}
+	}
+}
+
+// The zero-capacity set should be a valid empty set for every method.
+func TestEmptySet(t *testing.T) {
+	e := New(0)
+	c := NewFromIndices(100, []uint{5, 70})
+	if e.Cap() != 0 || e.Count() != 0 || e.Any() || !e.None() || !e.All() {
+		t.Errorf("New(0) should be an empty set")
+	}
+	if _, ok := e.NextSet(0); ok {
+		t.Errorf("NextSet on New(0) should find nothing")
+	}
+	if _, ok := e.NextClear(0); ok {
+		t.Errorf("NextClear on New(0) should find nothing")
+	}
+	if _, ok := e.FirstSet(); ok {
+		t.Errorf("FirstSet on New(0) should find nothing")
+	}
+	if _, ok := e.LastSet(); ok {
+		t.Errorf("LastSet on New(0) should find nothing")
+	}
+	if _, ok := e.Select(0); ok || e.Rank(0) != 0 || e.Rank(10) != 0 || e.CountRange(0, 0) != 0 {
+		t.Errorf("Rank, Select and CountRange on New(0) should see no bits")
+	}
+	if e.And(c).Cap() != 0 || e.And(c).Any() || e.AndNot(c).Any() || !e.Or(c).Equ(c) || !e.Xor(c).Equ(c) {
+		t.Errorf("Set operations with New(0) should treat it as empty")
+	}
+	if c.And(e).Any() || !c.AndNot(e).Equ(c) || !c.Or(e).Equ(c) {
+		t.Errorf("Set operations with New(0) as operand should treat it as empty")
+	}
+	if !e.Clone().Equ(e) || !e.Equ(New(0)) || e.Equ(New(1)) || !e.EqualBits(New(100)) {
+		t.Errorf("Clone and comparisons of New(0) are wrong")
+	}
+	if e.Intersects(c) || !e.IsSubset(c) || e.IsSuperset(c) || !c.IsSuperset(e) {
+		t.Errorf("Containment with New(0) is wrong")
+	}
+	if e.UnionCount(c) != 2 || e.IntersectionCount(c) != 0 || e.DifferenceCount(c) != 0 || e.SymmetricDifferenceCount(c) != 2 {
+		t.Errorf("Counts with New(0) are wrong")
+	}
+	if e.Hash() != New(0).Hash() || e.String() != "{}" || e.DumpAsBits() != "" || len(e.Indices()) != 0 {
+		t.Errorf("Renderings of New(0) are wrong")
+	}
+	if len(e.ToBytes()) != 0 || len(e.ToBoolSlice()) != 0 || len(e.WordCounts()) != 0 || len(e.RunLengthEncode()) != 0 {
+		t.Errorf("Conversions of New(0) should be empty")
+	}
+	if e.Sub(0, 0).Cap() != 0 || e.Shrink(5).Cap() != 0 || e.Complement().Cap() != 0 {
+		t.Errorf("Derived sets of New(0) should be empty")
+	}
+	if w, err := FromBytes(0, nil); err != nil || !w.Equ(e) {
+		t.Errorf("FromBytes(0, nil) should be an empty set")
+	}
+	if w, err := NewFromBits(""); err != nil || !w.Equ(e) {
+		t.Errorf("NewFromBits(\"\") should be an empty set")
+	}
+	if w, err := RunLengthDecode(0, nil); err != nil || !w.Equ(e) {
+		t.Errorf("RunLengthDecode(0, nil) should be an empty set")
+	}
+	data, _ := e.MarshalBinary()
+	var w BitSet
+	if err := w.UnmarshalBinary(data); err != nil || !w.Equ(e) {
+		t.Errorf("Binary round trip of New(0) failed: %v", err)
+	}
+
+	// mutators leave it valid and empty
+	e.Clear()
+	e.SetAll()
+	e.XorAll()
+	e.Flip()
+	e.SetRange(0, 0)
+	e.ClearRange(0, 0)
+	e.FlipRange(0, 0)
+	e.ShiftLeft(3)
+	e.ShiftRight(3)
+	e.Reverse()
+	e.RotateLeft(3)
+	e.RotateRight(3)
+	e.AndWith(c)
+	e.AndNotWith(c)
+	e.SetMany()
+	e.ClearMany()
+	e.Compact()
+	e.Reset(0)
+	if e.Cap() != 0 || e.Any() || e.Copy(c) != 0 || c.Any() {
+		t.Errorf("Mutators should leave New(0) empty")
+	}
+	if err := e.SetBitE(0); err == nil {
+		t.Errorf("SetBitE(0) on New(0) should be out of range")
+	}
+	e.OrWith(NewFromIndices(10, []uint{3}))
+	if e.Cap() != 10 || !e.Bit(3) {
+		t.Errorf("OrWith should grow New(0)")
+	}
+}
+
+func TestCountParallel(t *testing.T) {
+	r := rand.New(rand.NewSource(57))
+	for _, capacity := range []uint{0, 1, 64, 1000, 100000} {
+		v := randomSet(r, capacity, 3)
+		for _, workers := range []int{-1, 0, 1, 2, 3, 7, 16, 10000} {
+			if got := v.CountParallel(workers); got != v.Count() {
+				t.Errorf("CountParallel(%d) on capacity %d is %d, but it should be %d", workers, capacity, got, v.Count())
+			}
+		}
+	}
+	var n *BitSet
+	if n.CountParallel(4) != 0 {
+		t.Errorf("CountParallel on nil should be 0")
+	}
+}
+
+func BenchmarkCountParallel(b *testing.B) {
+	for _, capacity := range []uint{1 << 16, 1 << 20, 1 << 24, 1 << 28} {
+		v := New(capacity)
+		v.SetAll()
+		for _, workers := range []int{1, 4} {
+			b.Run(fmt.Sprintf("bits=%d/workers=%d", capacity, workers), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					v.CountParallel(workers)
+				}
+			})
+		}
+	}
+}
+
+func TestWords(t *testing.T) {
+	v := New(130)
+	if v.WordCount() != 3 {
+		t.Errorf("WordCount is %d, but it should be 3", v.WordCount())
+	}
+	v.SetWord(1, 0xf0)
+	if v.Word(1) != 0xf0 || !v.Bit(68) || v.Count() != 4 {
+		t.Errorf("SetWord(1, 0xf0) should set bits 68 to 71")
+	}
+	v.SetWord(2, ^uint64(0))
+	if v.Word(2) != 3 || v.Count() != 6 {
+		t.Errorf("SetWord on the final word should drop bits beyond the capacity, but it is %x", v.Word(2))
+	}
+	var n *BitSet
+	if n.WordCount() != 0 {
+		t.Errorf("WordCount of nil should be 0")
+	}
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("Word beyond WordCount should have caused a panic")
+		}
+	}()
+	v.Word(3)
+}
+
+func TestAppend(t *testing.T) {
+	r := rand.New(rand.NewSource(59))
+	caps := []uint{0, 1, 37, 63, 64, 65, 130}
+	for _, ca := range caps {
+		for _, cb := range caps {
+			a := randomSet(r, ca, 2)
+			b := randomSet(r, cb, 2)
+			v := a.Append(b)
+			if v.Cap() != ca+cb || v.Count() != a.Count()+b.Count() {
+				t.Errorf("Append(%d, %d) has capacity %d and %d bits", ca, cb, v.Cap(), v.Count())
+				continue
+			}
+			if !v.Sub(0, ca).Equ(a) || !v.Sub(ca, ca+cb).Equ(b) {
+				t.Errorf("Sub should recover both operands of Append(%d, %d)", ca, cb)
+			}
+		}
+	}
+	a := NewFromIndices(3, []uint{0})
+	if s := a.Append(NewFromIndices(2, []uint{1})).DumpAsBits(); s != "10001" {
+		t.Errorf("Append gave %s, but it should be 10001", s)
+	}
+}
+
+func TestOnesIndicesInto(t *testing.T) {
+	v := NewFromIndices(200, []uint{0, 64, 65, 199})
+	buf := make([]uint, 1, 10)
+	buf[0] = 7
+	got := v.OnesIndicesInto(buf)
+	if len(got) != 5 || got[0] != 7 || got[1] != 0 || got[2] != 64 || got[3] != 65 || got[4] != 199 {
+		t.Errorf("OnesIndicesInto returned %v, but it should be [7 0 64 65 199]", got)
+	}
+	if &got[0] != &buf[0] {
+		t.Errorf("OnesIndicesInto should reuse a slice with enough capacity")
+	}
+	r := rand.New(rand.NewSource(60))
+	w := randomSet(r, 5000, 3)
+	want := []uint{}
+	for i, ok := w.NextSet(0); ok; i, ok = w.NextSet(i + 1) {
+		want = append(want, i)
+	}
+	got = w.OnesIndicesInto(got[:0])
+	if len(got) != len(want) {
+		t.Fatalf("OnesIndicesInto found %d bits, but it should find %d", len(got), len(want))
+	}
+	for k := range want {
+		if got[k] != want[k] {
+			t.Errorf("OnesIndicesInto differs from NextSet at %d", k)
+			break
+		}
+	}
+}
+
+func BenchmarkOnesIndicesInto(b *testing.B) {
+	r := rand.New(rand.NewSource(60))
+	v := randomSet(r, 100000, 10)
+	buf := make([]uint, 0, v.Count())
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf = v.OnesIndicesInto(buf[:0])
+	}
+}
+
+func BenchmarkNextSetLoop(b *testing.B) {
+	r := rand.New(rand.NewSource(60))
+	v := randomSet(r, 100000, 10)
+	buf := make([]uint, 0, v.Count())
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf = buf[:0]
+		for j, ok := v.NextSet(0); ok; j, ok = v.NextSet(j + 1) {
+			buf = append(buf, j)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	r := rand.New(rand.NewSource(61))
+	acc := New(0)
+	want := map[uint]bool{}
+	maxCapacity := uint(0)
+	for k := 0; k < 50; k++ {
+		v := randomSet(r, uint(r.Intn(2000)), 50)
+		for _, i := range v.Indices() {
+			want[i] = true
+		}
+		maxCapacity = maxCap(maxCapacity, v.Cap())
+		acc.Merge(v)
+	}
+	if acc.Cap() != maxCapacity || acc.Count() != uint(len(want)) {
+		t.Errorf("Merge gave capacity %d and %d bits, but it should be %d and %d", acc.Cap(), acc.Count(), maxCapacity, len(want))
+	}
+	for i := range want {
+		if !acc.Bit(i) {
+			t.Errorf("Merge lost bit %d", i)
+		}
+	}
+}
+
+func TestSparsity(t *testing.T) {
+	v := New(200)
+	if v.Sparsity() != 0 || !v.IsSparse(0.01) {
+		t.Errorf("An empty set should have sparsity 0")
+	}
+	v.SetRange(0, 50)
+	if v.Sparsity() != 0.25 || !v.IsSparse(0.3) || v.IsSparse(0.25) {
+		t.Errorf("A quarter-full set should have sparsity 0.25, not %v", v.Sparsity())
+	}
+	v.SetAll()
+	if v.Sparsity() != 1 {
+		t.Errorf("A full set should have sparsity 1, not %v", v.Sparsity())
+	}
+	var n *BitSet
+	if New(0).Sparsity() != 0 || n.Sparsity() != 0 {
+		t.Errorf("A zero-capacity set should have sparsity 0")
+	}
+}
+
+func TestNewWithBits(t *testing.T) {
+	v := NewWithBits(10, 1, 3, 7)
+	if v.Cap() != 10 || v.DumpAsBits() != "0101000100" {
+		t.Errorf("NewWithBits(10, 1, 3, 7) is %s", v.DumpAsBits())
+	}
+	if w := NewWithBits(5); w.Cap() != 5 || w.Any() {
+		t.Errorf("NewWithBits with no bits should be empty")
+	}
+	defer func() {
+		if r := recover(); r != (ErrOutOfRange{10, 10}) {
+			t.Errorf("NewWithBits out of range panicked with %v", r)
+		}
+	}()
+	NewWithBits(10, 10)
+}
+
+func TestForEachSet(t *testing.T) {
+	r := rand.New(rand.NewSource(64))
+	v := randomSet(r, 1000, 5)
+	var got []uint
+	v.ForEachSet(func(i uint) bool {
+		if len(got) > 0 && i <= got[len(got)-1] {
+			t.Errorf("ForEachSet visited %d after %d", i, got[len(got)-1])
+		}
+		got = append(got, i)
+		return true
+	})
+	want := v.Indices()
+	if len(got) != len(want) {
+		t.Errorf("ForEachSet visited %d bits, but it should visit %d", len(got), len(want))
+	}
+	n := 0
+	v.ForEachSet(func(i uint) bool {
+		n++
+		return n < 3
+	})
+	if n != 3 {
+		t.Errorf("ForEachSet should stop when fn returns false, but called it %d times", n)
+	}
+}
+
+func TestNewSafe(t *testing.T) {
+	v, err := NewSafe(1000)
+	if err != nil || v.Cap() != 1000 || len(v.set) != 16 {
+		t.Errorf("NewSafe(1000) should behave like New(1000): %v", err)
+	}
+	for _, capacity := range []uint{MaxCapacity + 1, ^uint(0), ^uint(0) - 10} {
+		if MaxCapacity == ^uint(0) {
+			break
+		}
+		if _, err := NewSafe(capacity); err == nil {
+			t.Errorf("NewSafe(%d) should have failed", capacity)
+		}
+	}
+	if wordsFor(^uint(0)) != int(^uint(0)>>6)+1 {
+		t.Errorf("wordsFor should not overflow near the top of the uint range")
+	}
+}
+
+func TestNewBounded(t *testing.T) {
+	v, err := NewBounded(1000, 1000)
+	if err != nil || v.Cap() != 1000 {
+		t.Errorf("NewBounded(1000, 1000) should behave like New(1000): %v", err)
+	}
+	var n uint
+	for _, capacity := range []uint{1001, n - 1} {
+		if _, err := NewBounded(capacity, 1000); err == nil {
+			t.Errorf("NewBounded(%d, 1000) should have failed", capacity)
+		}
+	}
+	if MaxCapacity != ^uint(0) {
+		if _, err := NewBounded(MaxCapacity+1, ^uint(0)); err == nil {
+			t.Errorf("NewBounded should still reject capacities above MaxCapacity")
+		}
+	}
+}
+
+func TestNewHugePanics(t *testing.T) {
+	if MaxCapacity == ^uint(0) {
+		t.Skip("every capacity may be allocatable with 32-bit uint")
+	}
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("New with an unallocatable capacity should have caused a panic")
+		}
+	}()
+	New(^uint(0))
+}
+
+func TestChangedBits(t *testing.T) {
+	r := rand.New(rand.NewSource(66))
+	for k := 0; k < 100; k++ {
+		a := randomSet(r, uint(r.Intn(300)), 3)
+		b := randomSet(r, uint(r.Intn(300)), 3)
+		added, removed := a.ChangedBits(b)
+		var wantAdded, wantRemoved []uint
+		for i := uint(0); i < maxCap(a.Cap(), b.Cap()); i++ {
+			x, y := bitOrZero(a, i), bitOrZero(b, i)
+			if y && !x {
+				wantAdded = append(wantAdded, i)
+			}
+			if x && !y {
+				wantRemoved = append(wantRemoved, i)
+			}
+		}
+		if fmt.Sprint(added) != fmt.Sprint(wantAdded) || fmt.Sprint(removed) != fmt.Sprint(wantRemoved) {
+			t.Errorf("ChangedBits returned %v, %v, but it should be %v, %v", added, removed, wantAdded, wantRemoved)
+		}
+		if cap(added) != len(added) || cap(removed) != len(removed) {
+			t.Errorf("ChangedBits should allocate exactly sized slices")
+		}
+	}
+}
+
+func TestMarshalBinaryCompressed(t *testing.T) {
+	r := rand.New(rand.NewSource(67))
+	for _, capacity := range []uint{0, 1, 64, 65, 1000, 100000} {
+		for _, every := range []int{1, 3, 1000} {
+			v := randomSet(r, capacity, every)
+			data, err := v.MarshalBinaryCompressed()
+			if err != nil {
+				t.Fatalf("MarshalBinaryCompressed failed: %v", err)
+			}
+			var w BitSet
+			if err := w.UnmarshalBinaryCompressed(data); err != nil {
+				t.Fatalf("UnmarshalBinaryCompressed failed: %v", err)
+			}
+			if !w.Equ(v) {
+				t.Errorf("Compressed round trip of capacity %d did not preserve the set", capacity)
+			}
+		}
+	}
+}
+
+func TestMarshalBinaryCompressedSize(t *testing.T) {
+	v := NewWithBits(1000000, 10, 5000, 400000, 999999)
+	plain, _ := v.MarshalBinary()
+	data, _ := v.MarshalBinaryCompressed()
+	if len(data)*100 > len(plain) {
+		t.Errorf("Compressed form of a sparse million-bit set is %d bytes, plain is %d", len(data), len(plain))
+	}
+}
+
+func TestUnmarshalBinaryCompressedBounded(t *testing.T) {
+	v := NewWithBits(1000, 10, 999)
+	data, _ := v.MarshalBinaryCompressed()
+	var w BitSet
+	if err := w.UnmarshalBinaryCompressedBounded(data, 1000); err != nil || !w.Equ(v) {
+		t.Errorf("UnmarshalBinaryCompressedBounded within the bound should decode: %v", err)
+	}
+	if err := w.UnmarshalBinaryCompressedBounded(data, 999); err == nil {
+		t.Errorf("UnmarshalBinaryCompressedBounded above the bound should have failed")
+	}
+	// a few bytes claiming 2^40 bits must be rejected before allocating
+	bomb := binary.AppendUvarint([]byte{formatZeroRuns}, 1<<40)
+	if err := w.UnmarshalBinaryCompressed(bomb); err == nil {
+		t.Errorf("UnmarshalBinaryCompressed should reject a capacity above MaxDecodeCapacity")
+	}
+	big := binary.AppendUvarint([]byte{formatZeroRuns}, MaxDecodeCapacity+1)
+	if err := w.UnmarshalBinaryCompressed(big); err == nil {
+		t.Errorf("UnmarshalBinaryCompressed should reject a capacity just above MaxDecodeCapacity")
+	}
+	if err := w.UnmarshalBinaryCompressedBounded(bomb, 1<<20); err == nil {
+		t.Errorf("A compressed bomb should be rejected by the bound")
+	}
+	if !w.Equ(v) {
+		t.Errorf("A rejected decode should leave the set alone")
+	}
+}
+
+func TestUnmarshalBinaryCompressedBad(t *testing.T) {
+	v := NewWithBits(1000, 10, 999)
+	data, _ := v.MarshalBinaryCompressed()
+	plain, _ := v.MarshalBinary()
+	bad := [][]byte{nil, {0}, {2, 0}, {formatZeroRuns}, plain, data[:len(data)-1], append(data[:len(data):len(data)], 0)}
+	// a run longer than the words of the capacity
+	bad = append(bad, []byte{formatZeroRuns, 64, 2, 0})
+	// a literal with a bit beyond the capacity
+	bad = append(bad, []byte{formatZeroRuns, 1, 0, 1, 2, 0, 0, 0, 0, 0, 0, 0})
+	for _, d := range bad {
+		var w BitSet
+		if err := w.UnmarshalBinaryCompressed(d); err == nil {
+			t.Errorf("UnmarshalBinaryCompressed(%x) should have failed", d)
+		}
+	}
+}
+
+func TestSetIfClearIf(t *testing.T) {
+	for _, capacity := range []uint{0, 1, 64, 100, 1000} {
+		v, w := New(capacity), New(capacity)
+		v.SetIf(func(i uint) bool { return i%3 == 0 })
+		for i := uint(0); i < capacity; i += 3 {
+			w.SetBit(i)
+		}
+		if !v.Equ(w) {
+			t.Errorf("SetIf(i%%3 == 0) at capacity %d does not match SetBit loop", capacity)
+		}
+		v.ClearIf(func(i uint) bool { return i%2 == 0 })
+		for i := uint(0); i < capacity; i += 2 {
+			w.ClearBit(i)
+		}
+		if !v.Equ(w) {
+			t.Errorf("ClearIf(i%%2 == 0) at capacity %d does not match ClearBit loop", capacity)
+		}
+	}
+}
+
+func TestCommonPrefixLen(t *testing.T) {
+	r := rand.New(rand.NewSource(69))
+	for _, at := range []uint{0, 1, 63, 64, 65, 127, 128, 199} {
+		v := randomSet(r, 200, 2)
+		w := v.Clone()
+		w.FlipBit(at)
+		if n := v.CommonPrefixLen(w); n != at {
+			t.Errorf("Sets differing first at %d should share %d bits, got %d", at, at, n)
+		}
+	}
+	v := randomSet(r, 200, 2)
+	if n := v.CommonPrefixLen(v.Clone()); n != 200 {
+		t.Errorf("Identical sets of capacity 200 should share 200 bits, got %d", n)
+	}
+	short := v.Sub(0, 128)
+	if n := v.CommonPrefixLen(short); n != 128 {
+		t.Errorf("A 128-bit prefix should share 128 bits, got %d", n)
+	}
+	v.SetBit(130)
+	short = v.Sub(0, 129)
+	if n := short.CommonPrefixLen(v); n != 129 {
+		t.Errorf("A 129-bit prefix should share 129 bits, got %d", n)
+	}
+	var e *BitSet
+	if n := e.CommonPrefixLen(v); n != 0 {
+		t.Errorf("A nil set should share 0 bits, got %d", n)
+	}
+}
+
+func TestTrimToFit(t *testing.T) {
+	r := rand.New(rand.NewSource(70))
+	v := randomSet(r, 10000, 3)
+	v.ClearRange(500, 10000)
+	want := v.Indices()
+	last, _ := v.LastSet()
+	v.TrimToFit()
+	if v.Cap() != last+1 || len(v.set) != int(wordsFor(last+1)) {
+		t.Errorf("TrimToFit should cut capacity to %d, but Cap is %d in %d words", last+1, v.Cap(), len(v.set))
+	}
+	if got := v.Indices(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("TrimToFit should preserve the set bits")
+	}
+}
+
+func TestMarshalText(t *testing.T) {
+	v := NewWithBits(100, 3, 7, 99)
+	text, err := v.MarshalText()
+	if err != nil || string(text) != "cap=100;3,7,99" {
+		t.Errorf("MarshalText should give cap=100;3,7,99, got %q (%v)", text, err)
+	}
+	r := rand.New(rand.NewSource(71))
+	for _, capacity := range []uint{0, 1, 64, 1000, 5000} {
+		v := randomSet(r, capacity, 2)
+		text, _ := v.MarshalText()
+		var w BitSet
+		if err := w.UnmarshalText(text); err != nil {
+			t.Fatalf("UnmarshalText(%q) failed: %v", text, err)
+		}
+		if !w.Equ(v) {
+			t.Errorf("Text round trip of capacity %d did not preserve the set", capacity)
+		}
+	}
+	var e *BitSet
+	if text, _ := e.MarshalText(); string(text) != "cap=0;" {
+		t.Errorf("MarshalText of a nil set should give cap=0;, got %q", text)
+	}
+}
+
+func TestUnmarshalTextBounded(t *testing.T) {
+	var w BitSet
+	if err := w.UnmarshalTextBounded([]byte("cap=100;3,7,99"), 100); err != nil || w.String() != "{3,7,99}" {
+		t.Errorf("UnmarshalTextBounded within the bound should parse: %v", err)
+	}
+	for _, text := range []string{"cap=1000000000000000;", "cap=1099511627776;", "cap=1073741825;"} {
+		if err := w.UnmarshalText([]byte(text)); err == nil {
+			t.Errorf("UnmarshalText(%q) should reject a capacity above MaxDecodeCapacity", text)
+		}
+	}
+	if err := w.UnmarshalTextBounded([]byte("cap=1099511627776;"), 1<<20); err == nil {
+		t.Errorf("UnmarshalTextBounded should reject a capacity above the bound")
+	}
+	if w.String() != "{3,7,99}" {
+		t.Errorf("A rejected parse should leave the set alone")
+	}
+}
+
+func TestUnmarshalTextBad(t *testing.T) {
+	for _, s := range []string{"", "100;3", "cap=100", "cap=x;", "cap=-1;", "cap=100;3,,7", "cap=100;3,", "cap=100;100", "cap=100;a", "cap=100;-3", "cap=100; 3"} {
+		var w BitSet
+		if err := w.UnmarshalText([]byte(s)); err == nil {
+			t.Errorf("UnmarshalText(%q) should have failed", s)
+		}
+	}
+}
+
+func TestScalarOps(t *testing.T) {
+	r := rand.New(rand.NewSource(73))
+	for _, capacity := range []uint{0, 1, 64, 100, 1000} {
+		v := randomSet(r, capacity, 2)
+		w := v.Clone()
+		v.XorScalar(^uint64(0))
+		w.Flip()
+		if !v.Equ(w) || !v.EqualBits(w) {
+			t.Errorf("XorScalar(^0) at capacity %d should equal Flip", capacity)
+		}
+		v.OrScalar(0x5555555555555555)
+		v.AndScalar(0x5555555555555555)
+		for i := uint(0); i < capacity; i++ {
+			if v.Bit(i) != (i%2 == 0) {
+				t.Errorf("OrScalar then AndScalar of the even mask at capacity %d is wrong at %d", capacity, i)
+				break
+			}
+		}
+		if v.Count() != (capacity+1)/2 {
+			t.Errorf("OrScalar should not set padding bits: Count is %d at capacity %d", v.Count(), capacity)
+		}
+	}
+}
+
+func TestOrIndicesFrom(t *testing.T) {
+	r := rand.New(rand.NewSource(74))
+	v := randomSet(r, 5000, 7)
+	var stream []byte
+	for _, i := range v.Indices() {
+		stream = binary.AppendUvarint(stream, uint64(i))
+	}
+	w := randomSet(r, 5000, 7)
+	want := w.Or(v)
+	if err := w.OrIndicesFrom(bytes.NewReader(stream)); err != nil {
+		t.Fatalf("OrIndicesFrom failed: %v", err)
+	}
+	if !w.Equ(want) {
+		t.Errorf("OrIndicesFrom should union the streamed indices into the set")
+	}
+	w = New(5000)
+	if err := w.OrIndicesFrom(oneByteReader{bytes.NewReader(stream)}); err != nil || !w.Equ(v) {
+		t.Errorf("OrIndicesFrom through a plain io.Reader should read every index: %v", err)
+	}
+
+	truncated := binary.AppendUvarint([]byte{3}, 300)
+	w = New(5000)
+	if err := w.OrIndicesFrom(bytes.NewReader(truncated[:len(truncated)-1])); err != io.ErrUnexpectedEOF {
+		t.Errorf("A truncated varint should give io.ErrUnexpectedEOF, got %v", err)
+	}
+	if !w.Bit(3) {
+		t.Errorf("Indices before the truncation should be set")
+	}
+	var e ErrOutOfRange
+	if err := w.OrIndicesFrom(bytes.NewReader(binary.AppendUvarint(nil, 5000))); !errors.As(err, &e) || e.Index != 5000 {
+		t.Errorf("An index at the capacity should give ErrOutOfRange, got %v", err)
+	}
+}
+
+func TestHammingDistance(t *testing.T) {
+	r := rand.New(rand.NewSource(76))
+	for _, caps := range [][2]uint{{0, 0}, {64, 64}, {100, 1000}, {1000, 100}} {
+		v, w := randomSet(r, caps[0], 3), randomSet(r, caps[1], 3)
+		want := uint(0)
+		for i := uint(0); i < maxCap(caps[0], caps[1]); i++ {
+			if bitOrZero(v, i) != bitOrZero(w, i) {
+				want++
+			}
+		}
+		if d := v.HammingDistance(w); d != want {
+			t.Errorf("HammingDistance for capacities %v should be %d, got %d", caps, want, d)
+		}
+	}
+	v := randomSet(r, 256, 2)
+	w := v.Clone()
+	w.FlipBit(200)
+	if d := v.HammingDistance(w); d != 1 {
+		t.Errorf("Sets differing in one bit should be at distance 1, got %d", d)
+	}
+}
+
+func BenchmarkHammingDistance(b *testing.B) {
+	r := rand.New(rand.NewSource(76))
+	sigs := make([]*BitSet, 64)
+	for i := range sigs {
+		sigs[i] = randomSet(r, 256, 2)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		q := sigs[i%len(sigs)]
+		for _, s := range sigs {
+			q.HammingDistance(s)
+		}
+	}
+}
+
+func TestEqualPrefix(t *testing.T) {
+	r := rand.New(rand.NewSource(78))
+	v := randomSet(r, 300, 2)
+	for _, at := range []uint{0, 1, 63, 64, 65, 128, 250} {
+		w := v.Sub(0, 280)
+		w.FlipBit(at)
+		for _, n := range []uint{0, at, at + 1, 280} {
+			if eq := v.EqualPrefix(w, n); eq != (n <= at) {
+				t.Errorf("Sets differing first at %d: EqualPrefix(%d) should be %v", at, n, n <= at)
+			}
+		}
+	}
+	var e *BitSet
+	if !e.EqualPrefix(v, 0) {
+		t.Errorf("Any sets should share an empty prefix")
+	}
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("EqualPrefix beyond the shorter capacity should have caused a panic")
+		}
+	}()
+	v.EqualPrefix(New(100), 101)
+}
+
+func TestDownsample(t *testing.T) {
+	r := rand.New(rand.NewSource(79))
+	for _, caps := range [][2]uint{{0, 0}, {100, 0}, {100, 100}, {100, 10}, {100, 7}, {1000, 333}, {1000, 1}, {640, 64}} {
+		capacity, newCap := caps[0], caps[1]
+		for _, every := range []int{1, 5, 50} {
+			v := randomSet(r, capacity, every)
+			w := v.Downsample(newCap)
+			if w.Cap() != newCap {
+				t.Errorf("Downsample(%d) has capacity %d", newCap, w.Cap())
+			}
+			for j := uint(0); j < newCap; j++ {
+				start, end := (j*capacity+newCap-1)/newCap, ((j+1)*capacity+newCap-1)/newCap
+				want := false
+				for i := start; i < end; i++ {
+					want = want || v.Bit(i)
+				}
+				if w.Bit(j) != want {
+					t.Errorf("Downsample of %d to %d: bit %d should be %v", capacity, newCap, j, want)
+				}
+			}
+		}
+	}
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("Downsample to a larger capacity should have caused a panic")
+		}
+	}()
+	New(10).Downsample(11)
+}
+
+func TestMarshalDeltaVarint(t *testing.T) {
+	r := rand.New(rand.NewSource(81))
+	for _, capacity := range []uint{0, 1, 100, 1000, 10000} {
+		for _, every := range []int{1, 3, 500} {
+			v := randomSet(r, capacity, every)
+			w, err := UnmarshalDeltaVarint(capacity, v.MarshalDeltaVarint())
+			if err != nil {
+				t.Fatalf("UnmarshalDeltaVarint failed: %v", err)
+			}
+			if !w.Equ(v) {
+				t.Errorf("Delta varint round trip of capacity %d did not preserve the set", capacity)
+			}
+		}
+	}
+	v := New(100000000)
+	for i := uint(0); i < 3000; i++ {
+		v.SetBit(uint(r.Int63n(int64(v.Cap()))))
+	}
+	plain, _ := v.MarshalBinary()
+	if data := v.MarshalDeltaVarint(); len(data)*100 > len(plain) {
+		t.Errorf("Delta varint form of 3000 bits in a huge set is %d bytes, plain is %d", len(data), len(plain))
+	}
+}
+
+func TestUnmarshalDeltaVarintBad(t *testing.T) {
+	for _, d := range [][]byte{{100}, {99, 1}, {5, 0}, {0x80}, {50, 0x80}} {
+		if _, err := UnmarshalDeltaVarint(100, d); err == nil {
+			t.Errorf("UnmarshalDeltaVarint(100, %x) should have failed", d)
+		}
+	}
+	if v, err := UnmarshalDeltaVarint(100, []byte{0, 99}); err != nil || v.String() != "{0,99}" {
+		t.Errorf("UnmarshalDeltaVarint(100, 0063) should give {0,99}, got %v (%v)", v, err)
+	}
+}
+
+func TestFillLow(t *testing.T) {
+	r := rand.New(rand.NewSource(82))
+	for _, capacity := range []uint{0, 1, 63, 64, 65, 200} {
+		for _, n := range []uint{0, 1, 63, 64, 65, 128, 200, 1000} {
+			v := randomSet(r, capacity, 2)
+			v.FillLow(n)
+			w := New(capacity)
+			if n > capacity {
+				w.SetRange(0, capacity)
+			} else {
+				w.SetRange(0, n)
+			}
+			if !v.Equ(w) || !v.EqualBits(w) {
+				t.Errorf("FillLow(%d) at capacity %d should agree with SetRange on an empty set", n, capacity)
+			}
+		}
+	}
+}
+
+func TestIsContiguousFromZero(t *testing.T) {
+	for _, capacity := range []uint{1, 64, 65, 200} {
+		for _, n := range []uint{0, 1, 63, 64, 65, 199, 200} {
+			if n > capacity {
+				continue
+			}
+			v := New(capacity)
+			v.FillLow(n)
+			if got, ok := v.IsContiguousFromZero(); !ok || got != n {
+				t.Errorf("Prefix [0, %d) at capacity %d gave (%d, %v)", n, capacity, got, ok)
+			}
+			if n+1 < capacity {
+				v.SetBit(capacity - 1)
+				if _, ok := v.IsContiguousFromZero(); ok {
+					t.Errorf("Prefix [0, %d) with bit %d set should not be contiguous", n, capacity-1)
+				}
+			}
+		}
+	}
+	var e *BitSet
+	if n, ok := e.IsContiguousFromZero(); !ok || n != 0 {
+		t.Errorf("A nil set should be the empty prefix, got (%d, %v)", n, ok)
+	}
+	if n, ok := New(100).IsContiguousFromZero(); !ok || n != 0 {
+		t.Errorf("An empty set should be the empty prefix, got (%d, %v)", n, ok)
+	}
+}
+
+func TestInterleave(t *testing.T) {
+	r := rand.New(rand.NewSource(84))
+	for _, capacity := range []uint{0, 1, 31, 32, 33, 64, 65, 100, 1000} {
+		x, y := randomSet(r, capacity, 2), randomSet(r, capacity, 3)
+		z := Interleave(x, y)
+		if z.Cap() != 2*capacity {
+			t.Errorf("Interleave of capacity %d has capacity %d", capacity, z.Cap())
+		}
+		for i := uint(0); i < capacity; i++ {
+			if z.Bit(2*i) != x.Bit(i) || z.Bit(2*i+1) != y.Bit(i) {
+				t.Errorf("Interleave of capacity %d is wrong at %d", capacity, i)
+				break
+			}
+		}
+		x2, y2 := Deinterleave(z)
+		if !x2.Equ(x) || !y2.Equ(y) {
+			t.Errorf("Deinterleave of capacity %d did not restore the inputs", capacity)
+		}
+	}
+	for _, f := range []func(){
+		func() { Interleave(New(10), New(11)) },
+		func() { Deinterleave(New(11)) },
+	} {
+		func() {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Errorf("Mismatched capacities should have caused a panic")
+				}
+			}()
+			f()
+		}()
+	}
+}
+
+func TestMajority(t *testing.T) {
+	r := rand.New(rand.NewSource(86))
+	for _, n := range []int{1, 2, 3, 4, 5} {
+		for _, capacity := range []uint{0, 1, 64, 100, 1000} {
+			sets := make([]*BitSet, n)
+			for i := range sets {
+				sets[i] = randomSet(r, capacity, 2)
+			}
+			m, err := Majority(sets)
+			if err != nil {
+				t.Fatalf("Majority failed: %v", err)
+			}
+			for i := uint(0); i < capacity; i++ {
+				votes := 0
+				for _, s := range sets {
+					if s.Bit(i) {
+						votes++
+					}
+				}
+				if m.Bit(i) != (2*votes > n) {
+					t.Errorf("Majority of %d sets at bit %d with %d votes should be %v", n, i, votes, 2*votes > n)
+					break
+				}
+			}
+		}
+	}
+	tie, _ := Majority([]*BitSet{NewWithBits(10, 1, 2), NewWithBits(10, 1), NewWithBits(10, 2), NewWithBits(10, 1)})
+	if tie.String() != "{1}" {
+		t.Errorf("With 4 inputs a 2-2 tie should be clear, got %v", tie)
+	}
+	if _, err := Majority(nil); err == nil {
+		t.Errorf("Majority of no sets should have failed")
+	}
+	if _, err := Majority([]*BitSet{New(10), New(11)}); err == nil {
+		t.Errorf("Majority of mismatched capacities should have failed")
+	}
+}
+
+func TestRangeValidation(t *testing.T) {
+	ranges := map[string]func(v *BitSet, start, end uint){
+		"SetRange":   func(v *BitSet, start, end uint) { v.SetRange(start, end) },
+		"ClearRange": func(v *BitSet, start, end uint) { v.ClearRange(start, end) },
+		"FlipRange":  func(v *BitSet, start, end uint) { v.FlipRange(start, end) },
+		"CountRange": func(v *BitSet, start, end uint) { v.CountRange(start, end) },
+		"Sub":        func(v *BitSet, start, end uint) { v.Sub(start, end) },
+	}
+	cases := []struct {
+		start, end uint
+		want       string
+	}{
+		{10, 5, "range inverted: [10, 5) (capacity 100)"},
+		{100, 0, "range inverted: [100, 0) (capacity 100)"},
+		{5, 101, "range out of bounds: [5, 101) (capacity 100)"},
+		{101, 101, "range out of bounds: [101, 101) (capacity 100)"},
+	}
+	for name, f := range ranges {
+		for _, c := range cases {
+			v := NewWithBits(100, 3, 50)
+			func() {
+				defer func() {
+					if r := recover(); r != c.want {
+						t.Errorf("%s(%d, %d) should panic with %q, got %v", name, c.start, c.end, c.want, r)
+					}
+				}()
+				f(v, c.start, c.end)
+			}()
+			if v.String() != "{3,50}" {
+				t.Errorf("%s(%d, %d) should not touch the set, but it is %v", name, c.start, c.end, v)
+			}
+		}
+	}
+}
+
+func TestSelectRange(t *testing.T) {
+	r := rand.New(rand.NewSource(88))
+	v := randomSet(r, 2000, 3)
+	all := v.Indices()
+	n := uint(len(all))
+	for _, fr := range [][2]uint{{0, 0}, {0, 9}, {5, 70}, {63, 64}, {n - 1, n - 1}, {n - 5, n + 100}, {0, ^uint(0)}, {n, n + 5}, {10, 9}} {
+		from, to := fr[0], fr[1]
+		var want []uint
+		for k := from; k <= to && k < n; k++ {
+			want = append(want, all[k])
+		}
+		got := v.SelectRange(from, to)
+		if got == nil || fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("SelectRange(%d, %d) gave %v, want %v", from, to, got, want)
+		}
+	}
+	var e *BitSet
+	if got := e.SelectRange(0, 10); got == nil || len(got) != 0 {
+		t.Errorf("SelectRange of a nil set should be empty, got %v", got)
+	}
+}
+
+func BenchmarkSelectRange(b *testing.B) {
+	v := New(64000000)
+	for i := range v.set {
+		v.set[i] = ^uint64(0)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		v.SelectRange(1000, 1099)
+	}
+}
+
+func TestGetPutBits(t *testing.T) {
+	r := rand.New(rand.NewSource(90))
+	for k := 0; k < 1000; k++ {
+		v := randomSet(r, 200, 2)
+		count := uint(r.Intn(65))
+		start := uint(r.Intn(int(201 - count)))
+		var want uint64
+		for i := uint(0); i < count; i++ {
+			if v.Bit(start + i) {
+				want |= 1 << i
+			}
+		}
+		if got := v.GetBits(start, count); got != want {
+			t.Fatalf("GetBits(%d, %d) gave %x, want %x", start, count, got, want)
+		}
+		value := r.Uint64()
+		w := v.Clone()
+		w.PutBits(start, count, value)
+		for i := uint(0); i < 200; i++ {
+			expect := v.Bit(i)
+			if i >= start && i < start+count {
+				expect = value>>(i-start)&1 != 0
+			}
+			if w.Bit(i) != expect {
+				t.Fatalf("PutBits(%d, %d, %x) is wrong at bit %d", start, count, value, i)
+			}
+		}
+		if w.Count() > 200 || w.GetBits(start, count) != value&fieldMask(count) {
+			t.Fatalf("PutBits(%d, %d, %x) did not round trip through GetBits", start, count, value)
+		}
+	}
+	for _, f := range []func(){
+		func() { New(200).GetBits(0, 65) },
+		func() { New(200).GetBits(190, 11) },
+		func() { New(200).PutBits(150, 51, 0) },
+		func() { New(200).PutBits(0, 65, 0) },
+	} {
+		func() {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Errorf("A field beyond 64 bits or the capacity should have caused a panic")
+				}
+			}()
+			f()
+		}()
+	}
+}
+
+func TestHighLowSplit(t *testing.T) {
+	r := rand.New(rand.NewSource(91))
+	v := randomSet(r, 300000, 50)
+	v.SetBit(65535)
+	v.SetBit(65536)
+	buckets := v.HighLowSplit()
+	if len(buckets) != 5 {
+		t.Errorf("300000 bits should span 5 buckets, got %d", len(buckets))
+	}
+	w := New(v.Cap())
+	n := 0
+	for high, lows := range buckets {
+		for k, low := range lows {
+			if k > 0 && lows[k-1] >= low {
+				t.Errorf("Offsets of bucket %d should ascend", high)
+			}
+			w.SetBit(uint(high)<<16 | uint(low))
+			n++
+		}
+	}
+	if !w.Equ(v) || uint(n) != v.Count() {
+		t.Errorf("HighLowSplit buckets should rebuild the original indices")
+	}
+	if len(New(100).HighLowSplit()) != 0 {
+		t.Errorf("An empty set should have no buckets")
+	}
+}
+
+func TestCheckInvariants(t *testing.T) {
+	r := rand.New(rand.NewSource(92))
+	for _, capacity := range []uint{0, 1, 64, 100} {
+		v := randomSet(r, capacity, 2)
+		v.SetAll()
+		v.FlipRange(0, capacity)
+		v.XorScalar(^uint64(0))
+		if err := v.CheckInvariants(); err != nil {
+			t.Errorf("A set of capacity %d should satisfy the invariants: %v", capacity, err)
+		}
+	}
+	var e *BitSet
+	if err := e.CheckInvariants(); err != nil {
+		t.Errorf("A nil set should satisfy the invariants: %v", err)
+	}
+	short := &BitSet{capacity: 100, set: make([]uint64, 1)}
+	if err := short.CheckInvariants(); err == nil || !strings.Contains(err.Error(), "words") {
+		t.Errorf("A set missing a word should fail the invariants, got %v", err)
+	}
+	padded := New(100)
+	padded.set[1] = 1 << 40
+	if err := padded.CheckInvariants(); err == nil || !strings.Contains(err.Error(), "padding") {
+		t.Errorf("A set with padding bits should fail the invariants, got %v", err)
+	}
+}
+
+func TestNewBitsFrom(t *testing.T) {
+	r := rand.New(rand.NewSource(93))
+	for _, caps := range [][2]uint{{0, 0}, {100, 100}, {100, 1000}, {1000, 100}} {
+		v, w := randomSet(r, caps[0], 2), randomSet(r, caps[1], 3)
+		want := uint(0)
+		for i := uint(0); i < caps[1]; i++ {
+			if w.Bit(i) && !bitOrZero(v, i) {
+				want++
+			}
+		}
+		if n := v.NewBitsFrom(w); n != want {
+			t.Errorf("NewBitsFrom for capacities %v should be %d, got %d", caps, want, n)
+		}
+		before := v.Count()
+		v.OrWith(w)
+		if v.Count()-before != want {
+			t.Errorf("OrWith for capacities %v added %d bits, NewBitsFrom said %d", caps, v.Count()-before, want)
+		}
+	}
+}
+
+func TestSubWrapped(t *testing.T) {
+	r := rand.New(rand.NewSource(94))
+	for _, capacity := range []uint{1, 64, 100, 130} {
+		v := randomSet(r, capacity, 2)
+		for _, sl := range [][2]uint{{0, 0}, {0, capacity}, {capacity - 1, 2}, {capacity / 2, capacity}, {10, 3 * capacity}, {capacity + 5, 70}} {
+			start, length := sl[0], sl[1]
+			w := v.SubWrapped(start, length)
+			if w.Cap() != length || w.Count() > length {
+				t.Errorf("SubWrapped(%d, %d) has capacity %d", start, length, w.Cap())
+			}
+			for k := uint(0); k < length; k++ {
+				if w.Bit(k) != v.Bit((start+k)%capacity) {
+					t.Errorf("SubWrapped(%d, %d) of capacity %d is wrong at %d", start, length, capacity, k)
+					break
+				}
+			}
+		}
+	}
+	v := NewWithBits(100, 98, 99, 0, 2)
+	if n := testing.AllocsPerRun(10, func() { v.SubWrapped(50, 10000) }); n > 2 {
+		t.Errorf("SubWrapped should allocate only its result, but made %v allocations", n)
+	}
+	if w := v.SubWrapped(97, 6); w.String() != "{1,2,3,5}" {
+		t.Errorf("SubWrapped(97, 6) should be {1,2,3,5}, got %v", w)
+	}
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("A wrapped window of a zero-capacity set should have caused a panic")
+		}
+	}()
+	New(0).SubWrapped(0, 1)
+}
+
+func TestForEachWord(t *testing.T) {
+	r := rand.New(rand.NewSource(95))
+	for _, capacity := range []uint{0, 1, 64, 100, 1000} {
+		v := randomSet(r, capacity, 3)
+		v.SetAll()
+		v.ClearRange(0, capacity/3)
+		var got []uint
+		calls, next := 0, uint(0)
+		v.ForEachWord(func(base uint, word uint64) {
+			if base != next {
+				t.Errorf("ForEachWord at capacity %d gave base %d, want %d", capacity, base, next)
+			}
+			next += 64
+			calls++
+			for ; word != 0; word &= word - 1 {
+				got = append(got, base+uint(bits.TrailingZeros64(word)))
+			}
+		})
+		if calls != int(wordsFor(capacity)) || fmt.Sprint(got) != fmt.Sprint(v.Indices()) {
+			t.Errorf("ForEachWord at capacity %d should rebuild the indices from %d words", capacity, wordsFor(capacity))
+		}
+	}
+}
+
+func TestWithBoundsErrors(t *testing.T) {
+	v := NewWithOptions(100, WithBoundsErrors())
+	v.SetBit(5)
+	if v.Err() != nil {
+		t.Errorf("In-range SetBit should record no error, got %v", v.Err())
+	}
+	v.SetBit(100)
+	var e ErrOutOfRange
+	if !errors.As(v.Err(), &e) || e.Index != 100 || e.Capacity != 100 {
+		t.Errorf("SetBit(100) should record ErrOutOfRange, got %v", v.Err())
+	}
+	if v.Bit(200) || v.Count() != 1 {
+		t.Errorf("Out-of-range Bit should report false and leave the set alone")
+	}
+	v.ClearBit(300)
+	if !errors.Is(v.Err(), ErrOutOfRange{100, 100}) {
+		t.Errorf("Err should keep the first error, got %v", v.Err())
+	}
+	data, _ := v.MarshalBinary()
+	if err := v.UnmarshalBinary(data); err != nil || v.Err() == nil {
+		t.Errorf("Decoding should keep the recorded error: %v", err)
+	}
+	v.ClearBit(400)
+	if v.Bit(5) != true {
+		t.Errorf("Decoding should keep the bounds errors mode and the bits")
+	}
+
+	w := NewWithOptions(100)
+	if w.Err() != nil || w.Cap() != 100 {
+		t.Errorf("NewWithOptions without options should behave like New")
+	}
+	for _, f := range []func(){
+		func() { w.SetBit(100) },
+		func() { v.Clone().SetBit(100) },
+		func() { v.FlipBit(100) },
+	} {
+		func() {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Errorf("Outside Bit, SetBit and ClearBit under WithBoundsErrors, a bad index should panic")
+				}
+			}()
+			f()
+		}()
+	}
+	var n *BitSet
+	if n.Err() != nil {
+		t.Errorf("A nil set should have no error")
+	}
+}
+
+func TestSubtractCounting(t *testing.T) {
+	r := rand.New(rand.NewSource(97))
+	for _, caps := range [][2]uint{{0, 0}, {100, 100}, {100, 1000}, {1000, 100}} {
+		v, w := randomSet(r, caps[0], 2), randomSet(r, caps[1], 3)
+		want := v.AndNot(w)
+		before, common := v.Count(), v.IntersectionCount(w)
+		n := v.SubtractCounting(w)
+		if n != common || n+v.Count() != before || !v.Equ(want) {
+			t.Errorf("SubtractCounting for capacities %v removed %d of %d bits, leaving %d", caps, n, before, v.Count())
+		}
+	}
+	v := NewWithBits(100, 1, 2, 3)
+	if n := v.SubtractCounting(nil); n != 0 || v.Count() != 3 {
+		t.Errorf("SubtractCounting of nil should remove nothing, removed %d", n)
+	}
+}
+
+func TestSetFromLines(t *testing.T) {
+	v := New(100)
+	n, err := v.SetFromLines(strings.NewReader("3\n\n 7 \r\n99\n3\n"))
+	if err != nil || n != 4 || v.String() != "{3,7,99}" {
+		t.Errorf("SetFromLines read %d indices into %v: %v", n, v, err)
+	}
+	for _, c := range []struct {
+		text string
+		n    int
+		line string
+	}{
+		{"1\n2\nx\n4\n", 2, "line 3:"},
+		{"1\n\n100\n", 1, "line 3:"},
+		{"-1", 0, "line 1:"},
+		{"1 2\n", 0, "line 1:"},
+	} {
+		v := New(100)
+		n, err := v.SetFromLines(strings.NewReader(c.text))
+		if err == nil || !strings.Contains(err.Error(), c.line) || n != c.n {
+			t.Errorf("SetFromLines(%q) should fail at %s after %d indices, got %d: %v", c.text, c.line, c.n, n, err)
+		}
+	}
+	if n, err := New(10).SetFromLines(strings.NewReader("")); n != 0 || err != nil {
+		t.Errorf("SetFromLines of no lines should read nothing, got %d: %v", n, err)
+	}
+}
+
+func TestIntersectUnionAll(t *testing.T) {
+	r := rand.New(rand.NewSource(99))
+	for _, caps := range [][]uint{{0}, {100}, {100, 100}, {100, 1000, 64}, {1000, 1000, 1000, 1000}} {
+		sets := make([]*BitSet, len(caps))
+		for i, capacity := range caps {
+			sets[i] = randomSet(r, capacity, 1+i)
+		}
+		and, or := sets[0].Clone(), sets[0].Clone()
+		for _, s := range sets[1:] {
+			and, or = and.And(s), or.Or(s)
+		}
+		smallest := caps[0]
+		for _, capacity := range caps {
+			if capacity < smallest {
+				smallest = capacity
+			}
+		}
+		if i := IntersectAll(sets...); !i.Equ(and.Sub(0, smallest)) || i.Cap() != smallest {
+			t.Errorf("IntersectAll for capacities %v should match chained And", caps)
+		}
+		if u := UnionAll(sets...); !u.Equ(or) || u.Cap() != or.Cap() {
+			t.Errorf("UnionAll for capacities %v should match chained Or", caps)
+		}
+	}
+	if IntersectAll() != nil || UnionAll() != nil {
+		t.Errorf("IntersectAll and UnionAll of no sets should be nil")
+	}
+	if u := UnionAll(nil, NewWithBits(10, 3)); u.String() != "{3}" {
+		t.Errorf("UnionAll should treat nil as empty, got %v", u)
+	}
+}
+
+func benchmarkSets() []*BitSet {
+	r := rand.New(rand.NewSource(99))
+	sets := make([]*BitSet, 8)
+	for i := range sets {
+		sets[i] = randomSet(r, 1000000, 2)
+	}
+	return sets
+}
+
+func BenchmarkUnionAll(b *testing.B) {
+	sets := benchmarkSets()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		UnionAll(sets...)
+	}
+}
+
+func BenchmarkUnionChained(b *testing.B) {
+	sets := benchmarkSets()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		u := sets[0]
+		for _, s := range sets[1:] {
+			u = u.Or(s)
+		}
+	}
+}
+
+func BenchmarkIntersectAll(b *testing.B) {
+	sets := benchmarkSets()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		IntersectAll(sets...)
+	}
+}
+
+func BenchmarkIntersectChained(b *testing.B) {
+	sets := benchmarkSets()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		v := sets[0]
+		for _, s := range sets[1:] {
+			v = v.And(s)
+		}
+	}
+}
+
+func TestCopyBits(t *testing.T) {
+	r := rand.New(rand.NewSource(100))
+	for k := 0; k < 500; k++ {
+		src := randomSet(r, 300, 2)
+		dst := randomSet(r, 200, 3)
+		if k%2 == 0 {
+			// overlapping ranges within one set
+			dst = src
+		}
+		length := uint(r.Intn(int(dst.Cap()) + 1))
+		srcPos := uint(r.Intn(int(src.Cap()-length) + 1))
+		dstPos := uint(r.Intn(int(dst.Cap()-length) + 1))
+		want := dst.ToBoolSlice()
+		from := src.ToBoolSlice()
+		copy(want[dstPos:dstPos+length], from[srcPos:srcPos+length])
+		if err := CopyBits(dst, dstPos, src, srcPos, length); err != nil {
+			t.Fatalf("CopyBits failed: %v", err)
+		}
+		if fmt.Sprint(dst.ToBoolSlice()) != fmt.Sprint(want) || dst.CheckInvariants() != nil {
+			t.Fatalf("CopyBits(dst, %d, src, %d, %d) with same=%v is wrong", dstPos, srcPos, length, dst == src)
+		}
+	}
+	v := NewWithBits(200, 0, 63, 64, 130)
+	if err := CopyBits(v, 1, v, 0, 199); err != nil || v.String() != "{0,1,64,65,131}" {
+		t.Errorf("Overlapping CopyBits up by one should shift the bits, got %v (%v)", v, err)
+	}
+	if err := CopyBits(v, 0, v, 1, 199); err != nil || v.String() != "{0,63,64,130}" {
+		t.Errorf("Overlapping CopyBits down by one should shift the bits back, got %v (%v)", v, err)
+	}
+	for _, c := range [][3]uint{{0, 101, 100}, {1, 0, 100}, {0, 0, 101}, {^uint(0), 0, 2}} {
+		w, src := NewWithBits(100, 5), NewWithBits(100, 7)
+		if err := CopyBits(w, c[0], src, c[1], c[2]); err == nil || w.String() != "{5}" {
+			t.Errorf("CopyBits(dst, %d, src, %d, %d) should fail leaving dst alone", c[0], c[1], c[2])
+		}
+	}
+}
+
+func TestBoundsErrorsConcurrentBit(t *testing.T) {
+	v := NewWithOptions(100, WithBoundsErrors())
+	var wg sync.WaitGroup
+	for g := uint(0); g < 4; g++ {
+		wg.Add(1)
+		go func(g uint) {
+			defer wg.Done()
+			for k := uint(0); k < 100; k++ {
+				v.Bit(100 + g)
+				v.Bit(k)
+				v.Err()
+			}
+		}(g)
+	}
+	wg.Wait()
+	var e ErrOutOfRange
+	if !errors.As(v.Err(), &e) || e.Index < 100 || e.Index > 103 {
+		t.Errorf("Concurrent out-of-range Bit should record one of the bad indices, got %v", v.Err())
+	}
+}
+
+func TestClearErr(t *testing.T) {
+	v := NewWithOptions(100, WithBoundsErrors())
+	v.SetBit(100)
+	if n := testing.AllocsPerRun(10, func() { v.Bit(300) }); n != 0 {
+		t.Errorf("Out-of-range Bit after an error is kept should not allocate, made %v allocations", n)
+	}
+	v.ClearErr()
+	if v.Err() != nil {
+		t.Errorf("ClearErr should forget the error, got %v", v.Err())
+	}
+	v.ClearBit(200)
+	if !errors.Is(v.Err(), ErrOutOfRange{200, 100}) {
+		t.Errorf("After ClearErr the next error should be kept, got %v", v.Err())
+	}
+	v.Reset(50)
+	if v.Err() != nil {
+		t.Errorf("Reset should clear the error, got %v", v.Err())
+	}
+	v.SetBit(50)
+	if !errors.Is(v.Err(), ErrOutOfRange{50, 50}) {
+		t.Errorf("Reset should keep the bounds errors mode, got %v", v.Err())
+	}
+	var n *BitSet
+	n.ClearErr()
+}
@@ -0,0 +1,52 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bitset
+
+// BitSetBuilder collects bits whose highest index is not known in advance,
+// doubling its storage as needed, and then produces a BitSet sized to fit.
+// The zero value is ready to use. A BitSetBuilder is not safe for
+// concurrent use.
+type BitSetBuilder struct {
+	set    []uint64
+	minCap uint
+	extent uint
+}
+
+// Make a BitSetBuilder whose BitSet will have at least minCap capacity.
+func NewBitSetBuilder(minCap uint) *BitSetBuilder {
+	return &BitSetBuilder{minCap: minCap}
+}
+
+// Set bit i to 1, growing the builder if i is beyond its storage.
+func (bb *BitSetBuilder) Set(i uint) {
+	x := int(i >> 6)
+	if x >= len(bb.set) {
+		n := 2 * len(bb.set)
+		if n <= x {
+			n = x + 1
+		}
+		set := make([]uint64, n)
+		copy(set, bb.set)
+		bb.set = set
+	}
+	bb.set[x] |= 1 << (i & (64 - 1))
+	if i >= bb.extent {
+		bb.extent = i + 1
+	}
+}
+
+// The collected bits as a BitSet whose capacity is one past the highest
+// set bit, or the builder's minimum if that is larger. The builder hands
+// over its storage and starts again empty with the same minimum.
+func (bb *BitSetBuilder) Build() *BitSet {
+	capacity := maxCap(bb.extent, bb.minCap)
+	n := wordsFor(capacity)
+	set := bb.set
+	if n > len(set) {
+		set = append(set, make([]uint64, n-len(set))...)
+	}
+	bb.set, bb.extent = nil, 0
+	return &BitSet{capacity: capacity, set: set[:n]}
+}
@@ -0,0 +1,60 @@
+// Copyright 2011 Will Fitzgerald. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// This file tests incremental construction of bit sets
+
+package bitset
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestBitSetBuilder(t *testing.T) {
+	var bb BitSetBuilder
+	for _, i := range []uint{5, 1000, 70, 5} {
+		bb.Set(i)
+	}
+	v := bb.Build()
+	if v.Cap() != 1001 || !v.Equ(NewFromIndices(1001, []uint{5, 70, 1000})) {
+		t.Errorf("Build returned %v with capacity %d", v, v.Cap())
+	}
+	if w := bb.Build(); w.Cap() != 0 || w.Any() {
+		t.Errorf("A builder should start again empty after Build")
+	}
+	bb.Set(3)
+	if v.Bit(3) {
+		t.Errorf("Setting bits after Build should not change the built set")
+	}
+}
+
+func TestBitSetBuilderMinCap(t *testing.T) {
+	bb := NewBitSetBuilder(500)
+	bb.Set(10)
+	if v := bb.Build(); v.Cap() != 500 || !v.Bit(10) || v.Count() != 1 {
+		t.Errorf("Build should honour the minimum capacity 500, but has %d", v.Cap())
+	}
+	bb.Set(600)
+	if v := bb.Build(); v.Cap() != 601 {
+		t.Errorf("Build should exceed the minimum to hold bit 600, but has %d", v.Cap())
+	}
+	if v := NewBitSetBuilder(130).Build(); v.Cap() != 130 || len(v.set) != 3 || v.Any() {
+		t.Errorf("Build of an empty builder should give an empty set of the minimum capacity")
+	}
+}
+
+func TestBitSetBuilderRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(49))
+	var bb BitSetBuilder
+	want := New(100000)
+	for k := 0; k < 2000; k++ {
+		i := uint(r.Intn(100000))
+		bb.Set(i)
+		want.SetBit(i)
+	}
+	v := bb.Build()
+	if !v.EqualBits(want) || v.Cap() != want.extent() {
+		t.Errorf("Build should match setting the bits directly")
+	}
+}
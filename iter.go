@@ -0,0 +1,51 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.23
+
+package bitset
+
+import (
+	"iter"
+)
+
+// Iterator over the indices of the set bits in ascending order, for use
+// as
+//
+//	for i := range b.SetBits() {
+//		...
+//	}
+func (b *BitSet) SetBits() iter.Seq[uint] {
+	return b.ForEachSet
+}
+
+// Iterator over the indices of the clear bits below the capacity in
+// ascending order.
+func (b *BitSet) ClearBits() iter.Seq[uint] {
+	return func(yield func(uint) bool) {
+		for i, ok := b.NextClear(0); ok; i, ok = b.NextClear(i + 1) {
+			if !yield(i) {
+				return
+			}
+		}
+	}
+}
+
+// Iterator over the maximal runs of consecutive set bits in ascending
+// order, yielding the start and length of each. Bits 3, 4, 5 and 10
+// yield (3, 3) and (10, 1). Runs end at the capacity.
+func (b *BitSet) Runs() iter.Seq2[uint, uint] {
+	return func(yield func(uint, uint) bool) {
+		for start, ok := b.NextSet(0); ok; start, ok = b.NextSet(start) {
+			end, found := b.NextClear(start)
+			if !found {
+				end = b.Cap()
+			}
+			if !yield(start, end-start) {
+				return
+			}
+			start = end
+		}
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2011 Will Fitzgerald. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.23
+
+// This file tests range-over-func iteration
+
+package bitset
+
+import (
+	"testing"
+)
+
+func TestSetBitsIter(t *testing.T) {
+	v := NewFromIndices(200, []uint{0, 63, 64, 199})
+	var got []uint
+	for i := range v.SetBits() {
+		got = append(got, i)
+	}
+	if len(got) != 4 || got[0] != 0 || got[1] != 63 || got[2] != 64 || got[3] != 199 {
+		t.Errorf("SetBits yielded %v, but it should be [0 63 64 199]", got)
+	}
+	n := 0
+	for range v.SetBits() {
+		n++
+		if n == 2 {
+			break
+		}
+	}
+	if n != 2 {
+		t.Errorf("Breaking out of SetBits should stop after 2 bits, but saw %d", n)
+	}
+}
+
+func TestClearBitsIter(t *testing.T) {
+	v := New(130)
+	v.SetAll()
+	v.ClearBit(5)
+	v.ClearBit(129)
+	var got []uint
+	for i := range v.ClearBits() {
+		got = append(got, i)
+	}
+	if len(got) != 2 || got[0] != 5 || got[1] != 129 {
+		t.Errorf("ClearBits yielded %v, but it should be [5 129]", got)
+	}
+	n := uint(0)
+	for i := range New(70).ClearBits() {
+		if i != n {
+			t.Errorf("ClearBits yielded %d, but it should be %d", i, n)
+		}
+		n++
+	}
+	if n != 70 {
+		t.Errorf("ClearBits of New(70) yielded %d bits, but it should be 70", n)
+	}
+}
+
+func TestRuns(t *testing.T) {
+	v := NewFromIndices(200, []uint{3, 4, 5, 10})
+	v.SetRange(60, 130)
+	v.SetRange(190, 200)
+	type run struct{ start, length uint }
+	var got []run
+	for start, length := range v.Runs() {
+		got = append(got, run{start, length})
+	}
+	want := []run{{3, 3}, {10, 1}, {60, 70}, {190, 10}}
+	if len(got) != len(want) {
+		t.Fatalf("Runs yielded %v, but it should be %v", got, want)
+	}
+	for k := range want {
+		if got[k] != want[k] {
+			t.Errorf("Runs yielded %v, but it should be %v", got, want)
+			break
+		}
+	}
+	for range v.Runs() {
+		break
+	}
+	for range New(100).Runs() {
+		t.Errorf("An empty set should have no runs")
+	}
+	full := New(130)
+	full.SetAll()
+	for start, length := range full.Runs() {
+		if start != 0 || length != 130 {
+			t.Errorf("A full set should be one run (0, 130), not (%d, %d)", start, length)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bitset
+
+import (
+	"math/bits"
+	"sync"
+)
+
+// Released sets, bucketed by storage: pools[k] holds sets whose backing
+// slice has room for at least 1<<k words.
+var pools [bits.UintSize + 1]sync.Pool
+
+// Get an empty BitSet of exactly the given capacity, reusing the storage
+// of a released set when one large enough is pooled. Pair with Release
+// to spare the garbage collector in hot loops.
+func Acquire(capacity uint) *BitSet {
+	n := wordsFor(capacity)
+	if n == 0 {
+		return New(0)
+	}
+	k := bits.Len(uint(n - 1))
+	if b, ok := pools[k].Get().(*BitSet); ok {
+		b.capacity, b.set = capacity, b.set[:n]
+		return b
+	}
+	return &BitSet{capacity: capacity, set: make([]uint64, n, 1<<k)}
+}
+
+// Zero b, dropping any options, and return it to the pool for Acquire.
+// b must not be used after Release, by the caller or anyone holding it,
+// since a later Acquire may hand the same BitSet out again. Releasing nil
+// does nothing.
+func Release(b *BitSet) {
+	if b == nil || cap(b.set) == 0 {
+		return
+	}
+	b.set = b.set[:cap(b.set)]
+	for i := range b.set {
+		b.set[i] = 0
+	}
+	*b = BitSet{set: b.set}
+	pools[bits.Len(uint(cap(b.set)))-1].Put(b)
+}
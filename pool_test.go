@@ -0,0 +1,59 @@
+// Copyright 2011 Will Fitzgerald. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// This file tests pooled allocation of bit sets
+
+package bitset
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestAcquireRelease(t *testing.T) {
+	r := rand.New(rand.NewSource(85))
+	for k := 0; k < 100; k++ {
+		capacity := uint(r.Intn(5000))
+		b := Acquire(capacity)
+		if b.Cap() != capacity || len(b.set) != int(wordsFor(capacity)) || b.Any() {
+			t.Fatalf("Acquire(%d) gave Cap %d with %d words, Count %d", capacity, b.Cap(), len(b.set), b.Count())
+		}
+		for i := uint(0); i < capacity; i += 3 {
+			b.SetBit(i)
+		}
+		Release(b)
+	}
+	b := NewWithBits(1000, 1, 999)
+	Release(b)
+	if b.Cap() != 0 || b.Any() {
+		t.Errorf("Release should zero the set")
+	}
+	o := NewWithOptions(1000, WithBoundsErrors())
+	o.SetBit(1000)
+	Release(o)
+	if o.boundsErrors || o.Err() != nil {
+		t.Errorf("Release should drop options and errors")
+	}
+	Release(nil)
+	if b := Acquire(0); b.Cap() != 0 {
+		t.Errorf("Acquire(0) should give an empty set")
+	}
+}
+
+func BenchmarkAcquireRelease(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		v := Acquire(10000)
+		v.SetBit(uint(i) % 10000)
+		Release(v)
+	}
+}
+
+func BenchmarkNewInLoop(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		v := New(10000)
+		v.SetBit(uint(i) % 10000)
+	}
+}
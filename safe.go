@@ -0,0 +1,149 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bitset
+
+import (
+	"sync"
+)
+
+// SafeBitSet wraps a BitSet with a sync.RWMutex so that it can be shared
+// between goroutines. Queries take the read lock and mutators the write
+// lock. Each call is atomic on its own, but a sequence of calls is not:
+// a loop over NextSet may observe bits changing between steps. Use
+// WithLock for compound operations.
+type SafeBitSet struct {
+	mu sync.RWMutex
+	b  *BitSet
+}
+
+// Make a SafeBitSet with an upper limit on size.
+func NewSafeBitSet(capacity uint) *SafeBitSet {
+	return &SafeBitSet{b: New(capacity)}
+}
+
+// Call fn with the underlying BitSet while holding the write lock. fn
+// must not retain the BitSet or call methods of s.
+func (s *SafeBitSet) WithLock(fn func(b *BitSet)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(s.b)
+}
+
+// Query maximum size of the bit set
+func (s *SafeBitSet) Cap() uint {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.b.Cap()
+}
+
+// Test whether bit i is set.
+func (s *SafeBitSet) Bit(i uint) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.b.Bit(i)
+}
+
+// Set bit i to 1
+func (s *SafeBitSet) SetBit(i uint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.b.SetBit(i)
+}
+
+// Clear bit i to 0
+func (s *SafeBitSet) ClearBit(i uint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.b.ClearBit(i)
+}
+
+// Set bit i to value
+func (s *SafeBitSet) SetTo(i uint, value bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.b.SetTo(i, value)
+}
+
+// Flip bit i and return its new value
+func (s *SafeBitSet) FlipBit(i uint) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.FlipBit(i)
+}
+
+// Clear entire bit set
+func (s *SafeBitSet) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.b.Clear()
+}
+
+// Set every bit below the capacity
+func (s *SafeBitSet) SetAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.b.SetAll()
+}
+
+// Count (number of set bits)
+func (s *SafeBitSet) Count() uint {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.b.Count()
+}
+
+// Test whether any bit is set.
+func (s *SafeBitSet) Any() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.b.Any()
+}
+
+// Test whether no bit is set.
+func (s *SafeBitSet) None() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.b.None()
+}
+
+// Test whether every bit below the capacity is set.
+func (s *SafeBitSet) All() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.b.All()
+}
+
+// Index of the first set bit at position i or higher; see BitSet.NextSet.
+// Successive calls are not atomic with respect to each other.
+func (s *SafeBitSet) NextSet(i uint) (uint, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.b.NextSet(i)
+}
+
+// Index of the first clear bit at position i or higher; see
+// BitSet.NextClear. Successive calls are not atomic with respect to each
+// other.
+func (s *SafeBitSet) NextClear(i uint) (uint, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.b.NextClear(i)
+}
+
+// Render the set bits as in BitSet.String.
+func (s *SafeBitSet) String() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.b.String()
+}
+
+// Copy of the current set, taken under the read lock. The copy shares no
+// storage with s, so it can be handed to another goroutine and read
+// without locking while s keeps changing.
+func (s *SafeBitSet) Snapshot() *BitSet {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.b.Clone()
+}
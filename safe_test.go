@@ -0,0 +1,82 @@
+// Copyright 2011 Will Fitzgerald. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// This file tests the synchronized bit set
+
+package bitset
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestSafeBitSet(t *testing.T) {
+	s := NewSafeBitSet(1000)
+	s.SetBit(10)
+	s.SetTo(20, true)
+	if !s.FlipBit(30) || !s.Bit(10) || !s.Bit(20) || s.Count() != 3 {
+		t.Errorf("SafeBitSet should have bits 10, 20 and 30 set, but has %v", s)
+	}
+	if i, ok := s.NextSet(11); !ok || i != 20 {
+		t.Errorf("NextSet(11) returned %d, %v, but it should be 20, true", i, ok)
+	}
+	if i, ok := s.NextClear(10); !ok || i != 11 {
+		t.Errorf("NextClear(10) returned %d, %v, but it should be 11, true", i, ok)
+	}
+	s.ClearBit(10)
+	if s.Bit(10) || !s.Any() || s.None() || s.All() || s.Cap() != 1000 {
+		t.Errorf("SafeBitSet predicates are wrong after ClearBit")
+	}
+	s.SetAll()
+	if !s.All() {
+		t.Errorf("SafeBitSet should have All after SetAll")
+	}
+	s.Clear()
+	if !s.None() {
+		t.Errorf("SafeBitSet should have None after Clear")
+	}
+}
+
+func TestSafeBitSetConcurrent(t *testing.T) {
+	s := NewSafeBitSet(64)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g uint) {
+			defer wg.Done()
+			for k := 0; k < 1000; k++ {
+				i := (g*8 + uint(k)) % 64
+				s.SetBit(i)
+				s.Bit(i)
+				s.Count()
+				s.WithLock(func(b *BitSet) {
+					if !b.Bit(i) {
+						b.SetBit(i)
+					}
+				})
+			}
+		}(uint(g))
+	}
+	wg.Wait()
+	if s.Count() != 64 {
+		t.Errorf("Count reported as %d, but it should be 64", s.Count())
+	}
+}
+
+func TestSafeBitSetSnapshot(t *testing.T) {
+	s := NewSafeBitSet(1000)
+	s.SetBit(10)
+	s.SetBit(999)
+	snap := s.Snapshot()
+	s.SetBit(20)
+	s.ClearBit(10)
+	s.WithLock(func(b *BitSet) { b.Grow(2000) })
+	if snap.Cap() != 1000 || !snap.Bit(10) || snap.Bit(20) || !snap.Bit(999) || snap.Count() != 2 {
+		t.Errorf("Snapshot should not see later mutations, but it is %v", snap)
+	}
+	snap.SetBit(500)
+	if s.Bit(500) {
+		t.Errorf("Mutating the snapshot should not affect the SafeBitSet")
+	}
+}